@@ -53,7 +53,7 @@ func (f *Authorizer) ttlCacheChecker() (*ServiceContext, error) {
 				if !f.doNotFlushAuthorizedHosts {
 					for h := range f.cache.Hosts {
 						l.Infof("Removing host [%s] from firewall rules", h)
-						err := f.fw.DeleteIPv4FromAuthorizedList(f.authorizedSet, h)
+						err := f.fw.DeleteFromAuthorizedList(f.authorizedSet, h)
 						if err != nil {
 							l.Error(err)
 						}
@@ -69,9 +69,14 @@ func (f *Authorizer) ttlCacheChecker() (*ServiceContext, error) {
 
 				return
 			case <-ticker.C:
-				if f.cache.TTL < 0 {
+				// Blocking call. If the expired hosts or cache is very big we may get dns bottleneck.
+				// During f.cache.Expired() call, RequestHandler will not be able to serve dns requests
+				l.Debug("Checking cache for expired hosts")
+				expired := f.cache.Expired()
+				if len(expired) == 0 {
 					break
 				}
+
 				l.Debug("Gathering active hosts from conntrack")
 				activeHosts, err := f.conntrackDump()
 				if err != nil {
@@ -79,21 +84,18 @@ func (f *Authorizer) ttlCacheChecker() (*ServiceContext, error) {
 					continue
 				}
 
-				// Blocking call. If the expired hosts or cache is very big we may get dns bottleneck.
-				// During f.cache.Expired() call, RequestHandler will not be able to serve dns requests
-				l.Debug("Checking cache for expired hosts")
-				for _, h := range f.cache.Expired() {
+				for _, h := range expired {
 					_, ok := activeHosts[h]
 					if ok {
 						l.Debugf("Host [%s] has expired but is stil active. Renewing", h)
-						f.cache.Renew(h)
+						f.cache.Extend(h)
 						continue
 					}
 
 					// Blocking call, but we expect this to be fast to mitigate any wait that
 					// RequestHandler may encounter
 					l.Debugf("Host [%s] has expired. Removing from firewall rules", h)
-					err := f.fw.DeleteIPv4FromAuthorizedList(f.authorizedSet, h)
+					err := f.fw.DeleteFromAuthorizedList(f.authorizedSet, h)
 					if err != nil {
 						l.Error(err)
 					}
@@ -19,13 +19,19 @@ type Authorizer struct {
 	conntrack                         *conntrack.Conn
 	blacklistHosts, blacklistNetworks []string
 	ttl, ttlCheckTicker               int
+	ttlMode                           string
+	answerTTL                         AnswerTTL
 	authorizedSet                     string
 	doNotFlushAuthorizedHosts         bool
 }
 
-// NewAuthorizer for creating a new Authorizer
+// NewAuthorizer for creating a new Authorizer. In fixed ttlMode authorized hosts expire after ttl
+// seconds (-1 to never expire), in answer ttlMode they expire based on the TTL of the answer record
+// bounded by answerTTL. Expired hosts are checked every ttlCheckTicker seconds
 func NewAuthorizer(
-	ttl,
+	ttl int,
+	ttlMode string,
+	answerTTL AnswerTTL,
 	ttlCheckTicker int,
 	authorizedSet string,
 	blacklistHosts, blacklistNetworks []string,
@@ -43,12 +49,23 @@ func NewAuthorizer(
 		blacklistNetworks:         blacklistNetworks,
 		ttl:                       ttl,
 		ttlCheckTicker:            ttlCheckTicker,
+		ttlMode:                   ttlMode,
+		answerTTL:                 answerTTL,
 		authorizedSet:             authorizedSet,
 		fw:                        fw,
-		cache:                     cache.NewCache(ttl),
+		cache:                     cache.NewCache(),
 		doNotFlushAuthorizedHosts: doNotFlushAuthorizedHosts,
 	}
 
+	if authorizer.ttlMode == "" {
+		authorizer.ttlMode = TTLModeFixed
+	}
+
+	err := checkTTLMode(authorizer.ttlMode, authorizer.answerTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	c, err := conntrack.Dial(nil)
 	if err != nil {
 		return nil, nil, err
@@ -76,6 +93,16 @@ func NewAuthorizer(
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if authorizer.fw.IPv6() {
+		hosts6, err := authorizer.fw.GetIPv6AuthorizedHosts(authorizedSet)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hosts = append(hosts, hosts6...)
+	}
+	// The answers that authorized imported hosts are not known, in answer ttl
+	// mode they are kept for the maximum lifetime
 	if len(hosts) > 0 {
 		for _, h := range hosts {
 			authorizer.fwl.Debugf(
@@ -83,7 +110,7 @@ func NewAuthorizer(
 				h,
 				authorizedSet,
 			)
-			authorizer.cache.Register(h.String())
+			authorizer.cache.Register(h.String(), authorizer.lifetime(uint32(authorizer.answerTTL.Max)))
 		}
 	}
 
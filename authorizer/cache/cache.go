@@ -5,18 +5,68 @@ import (
 	"time"
 )
 
-// Authorized for storing Authorized DNS Hosts
+// maxTracked is the maximum number of domains and clients recorded per host. Queries for
+// more domains or from more clients are still counted in Host.Queries
+const maxTracked = 64
+
+// Host for describing an authorized host. Domains holds the domains that resolved to the host
+// and Clients the clients that asked for them, both with the number of queries. FirstSeen and
+// LastSeen are the times of the first and the last query, Renewed is the last time the host
+// was authorized or found active. The host expires at Expires, or never if Expires is zero.
+// Lifetime is the lifetime the host was last renewed with
+type Host struct {
+	Domains   map[string]int
+	Clients   map[string]int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Renewed   time.Time
+	Expires   time.Time
+	Lifetime  time.Duration
+	Queries   int
+}
+
+// renew sets the expiry of h to lifetime from now. An expiry that is later is kept, since
+// another answer may have authorized the host for longer. A lifetime of 0 or less never expires
+func (h *Host) renew(lifetime time.Duration) {
+	now := time.Now()
+	h.Renewed = now
+	h.Lifetime = lifetime
+
+	if lifetime <= 0 {
+		h.Expires = time.Time{}
+		return
+	}
+
+	if expires := now.Add(lifetime); expires.After(h.Expires) {
+		h.Expires = expires
+	}
+}
+
+// copy returns a deep copy of h
+func (h *Host) copy() Host {
+	c := *h
+	c.Domains = make(map[string]int, len(h.Domains))
+	for k, v := range h.Domains {
+		c.Domains[k] = v
+	}
+	c.Clients = make(map[string]int, len(h.Clients))
+	for k, v := range h.Clients {
+		c.Clients[k] = v
+	}
+
+	return c
+}
+
+// Authorized for storing Authorized DNS Hosts. Every host has its own expiry
 type Authorized struct {
 	sync.Mutex
-	TTL   int
-	Hosts map[string]time.Time
+	Hosts map[string]*Host
 }
 
 // NewCache creates a new empty cache
-func NewCache(ttl int) *Authorized {
+func NewCache() *Authorized {
 	return &Authorized{
-		TTL:   ttl,
-		Hosts: make(map[string]time.Time),
+		Hosts: make(map[string]*Host),
 	}
 }
 
@@ -28,7 +78,7 @@ func (c *Authorized) NewAuthMap() {
 	c.Lock()
 	defer c.Unlock()
 
-	newMap := make(map[string]time.Time)
+	newMap := make(map[string]*Host)
 
 	for k, v := range c.Hosts {
 		newMap[k] = v
@@ -47,8 +97,9 @@ func (c *Authorized) Exists(h string) bool {
 	return ok
 }
 
-// Register (blocking) for adding a new host to cache
-func (c *Authorized) Register(h string) bool {
+// Register (blocking) for adding a new host to cache that expires after lifetime.
+// A lifetime of 0 or less never expires
+func (c *Authorized) Register(h string, lifetime time.Duration) bool {
 	if c.Exists(h) {
 		return false
 	}
@@ -56,31 +107,106 @@ func (c *Authorized) Register(h string) bool {
 	c.Lock()
 	defer c.Unlock()
 
-	c.Hosts[h] = time.Now()
+	host := &Host{
+		Domains: make(map[string]int),
+		Clients: make(map[string]int),
+	}
+	host.renew(lifetime)
+	c.Hosts[h] = host
 
 	return true
 }
 
-// Renew (blocking) for updating a hosts registration time in cache
-func (c *Authorized) Renew(h string) {
+// Renew (blocking) for extending a hosts expiry to lifetime from now
+func (c *Authorized) Renew(h string, lifetime time.Duration) {
 	c.Lock()
 	defer c.Unlock()
 
-	c.Hosts[h] = time.Now()
+	host, ok := c.Hosts[h]
+	if !ok {
+		return
+	}
+	host.renew(lifetime)
 }
 
-// Expired (blocking) for returning all expired hosts. Returns empty slice if c.TTL is < 0
-func (c *Authorized) Expired() []string {
-	if c.TTL < 0 {
-		return []string{}
+// Extend (blocking) for extending a hosts expiry by the lifetime it was last renewed with
+func (c *Authorized) Extend(h string) {
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.Hosts[h]
+	if !ok {
+		return
+	}
+	host.renew(host.Lifetime)
+}
+
+// Record (blocking) for recording a query for domain from client that resolved to host h.
+// client can be empty if it is not known
+func (c *Authorized) Record(h, domain, client string) {
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.Hosts[h]
+	if !ok {
+		return
 	}
 
+	now := time.Now()
+	if host.FirstSeen.IsZero() {
+		host.FirstSeen = now
+	}
+	host.LastSeen = now
+	host.Queries++
+
+	if _, ok := host.Domains[domain]; ok || len(host.Domains) < maxTracked {
+		host.Domains[domain]++
+	}
+
+	if client == "" {
+		return
+	}
+
+	if _, ok := host.Clients[client]; ok || len(host.Clients) < maxTracked {
+		host.Clients[client]++
+	}
+}
+
+// Get (blocking) returns a copy of the record of host h
+func (c *Authorized) Get(h string) (Host, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.Hosts[h]
+	if !ok {
+		return Host{}, false
+	}
+
+	return host.copy(), true
+}
+
+// List (blocking) returns a copy of the records of all hosts
+func (c *Authorized) List() map[string]Host {
+	c.Lock()
+	defer c.Unlock()
+
+	hosts := make(map[string]Host, len(c.Hosts))
+	for h, host := range c.Hosts {
+		hosts[h] = host.copy()
+	}
+
+	return hosts
+}
+
+// Expired (blocking) for returning all expired hosts. Hosts without an expiry are never returned
+func (c *Authorized) Expired() []string {
 	c.Lock()
 	defer c.Unlock()
 
+	now := time.Now()
 	hosts := []string{}
-	for h, t := range c.Hosts {
-		if time.Since(t) > time.Second*time.Duration(c.TTL) {
+	for h, host := range c.Hosts {
+		if !host.Expires.IsZero() && now.After(host.Expires) {
 			hosts = append(hosts, h)
 		}
 	}
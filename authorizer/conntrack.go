@@ -2,7 +2,6 @@ package authorizer
 
 import (
 	"fmt"
-	"strings"
 )
 
 // conntrackDump, not blocking for now. For now, we expect activeHosts to
@@ -26,10 +25,10 @@ func (f *Authorizer) conntrackDump() (map[string]struct{}, error) {
 	// The good thing is that this call is not blocking. The ttl checker may hang while waiting for
 	// the activeHosts list, however during the wait, RequestHandler is free to call cache
 	for _, j := range df {
-		activeHosts[strings.Split(j.TupleOrig.IP.DestinationAddress.String(), ":")[0]] = struct{}{}
-		activeHosts[strings.Split(j.TupleOrig.IP.SourceAddress.String(), ":")[0]] = struct{}{}
-		activeHosts[strings.Split(j.TupleReply.IP.DestinationAddress.String(), ":")[0]] = struct{}{}
-		activeHosts[strings.Split(j.TupleReply.IP.SourceAddress.String(), ":")[0]] = struct{}{}
+		activeHosts[j.TupleOrig.IP.DestinationAddress.String()] = struct{}{}
+		activeHosts[j.TupleOrig.IP.SourceAddress.String()] = struct{}{}
+		activeHosts[j.TupleReply.IP.DestinationAddress.String()] = struct{}{}
+		activeHosts[j.TupleReply.IP.SourceAddress.String()] = struct{}{}
 	}
 
 	return activeHosts, nil
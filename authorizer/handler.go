@@ -8,14 +8,20 @@ import (
 	"github.com/miekg/dns"
 )
 
-// HandleRequest for filtering dns respone requests
-func (f *Authorizer) HandleRequest(resp *dns.Msg) error {
+// HandleRequest for filtering dns respone requests. client is the host that sent the query, it is
+// recorded along with the question for every authorized host. client can be nil if it is not known
+func (f *Authorizer) HandleRequest(resp *dns.Msg, client net.IP) error {
 	if f.cache == nil {
 		return fmt.Errorf(errNil)
 	}
 
 	question := resp.Question[0].Name
 
+	var clientAddr string
+	if client != nil {
+		clientAddr = client.String()
+	}
+
 	if resp.Rcode == dns.RcodeNameError {
 		f.fwl.Infof(infoNXDomain, question)
 		return nil
@@ -49,7 +55,7 @@ func (f *Authorizer) HandleRequest(resp *dns.Msg) error {
 				continue
 			}
 
-			err := f.authIPv4(question, r.A.String())
+			err := f.authIP(question, r.A.String(), clientAddr, r.Hdr.Ttl)
 			if err != nil {
 				f.fwl.Error(err)
 			}
@@ -60,9 +66,24 @@ func (f *Authorizer) HandleRequest(resp *dns.Msg) error {
 
 	if resp.Question[0].Qtype == dns.TypeAAAA {
 		for _, answer := range resp.Answer {
-			if r, ok := answer.(*dns.AAAA); ok {
-				// Not supported yet
+			if _, ok := answer.(*dns.CNAME); ok {
+				continue
+			}
+
+			r, ok := answer.(*dns.AAAA)
+			if !ok {
+				f.fwl.Errorf(errInvalidReply, "TypeAAAA", question)
+				continue
+			}
+
+			if !f.fw.IPv6() {
 				f.fwl.Warnf(warnIPv6Support, question, r.AAAA.String())
+				continue
+			}
+
+			err := f.authIP(question, r.AAAA.String(), clientAddr, r.Hdr.Ttl)
+			if err != nil {
+				f.fwl.Error(err)
 			}
 		}
 
@@ -71,6 +92,7 @@ func (f *Authorizer) HandleRequest(resp *dns.Msg) error {
 
 	if resp.Question[0].Qtype == dns.TypePTR {
 		answerSlice := []string{}
+		var ttl uint32
 		for _, answer := range resp.Answer {
 			r, ok := answer.(*dns.PTR)
 			if !ok {
@@ -78,30 +100,35 @@ func (f *Authorizer) HandleRequest(resp *dns.Msg) error {
 				continue
 			}
 			answerSlice = append(answerSlice, r.Ptr)
+			if len(answerSlice) == 1 || r.Hdr.Ttl < ttl {
+				ttl = r.Hdr.Ttl
+			}
 		}
 
 		if t := strings.Split(question, ".arpa")[0]; strings.HasSuffix(t, ".ip6") {
-			f.fwl.Warnf(warnPTRIPv6, question, strings.Join(answerSlice, " "))
-			return nil
-		}
+			if !f.fw.IPv6() {
+				f.fwl.Warnf(warnPTRIPv6, question, strings.Join(answerSlice, " "))
+				return nil
+			}
 
-		// Split PTR Question BE IPv4 string
-		revAddr := strings.Split(question, ".in-addr.arpa")[0]
+			addr, err := ptrIPv6(strings.TrimSuffix(t, ".ip6"))
+			if err != nil {
+				return err
+			}
+			f.fwl.Infof(infoPTRIPv6, question, addr, strings.Join(answerSlice, " "))
 
-		// Split BE IPv4 string into a slice
-		revAddrSlice := strings.Split(revAddr, ".")
-		addrSlice := []string{}
+			err = f.authIP(question, addr, clientAddr, ttl)
+			if err != nil {
+				f.fwl.Error(err)
+			}
 
-		// Convert to LE
-		for i := len(revAddrSlice) - 1; i >= 0; i-- {
-			addrSlice = append(addrSlice, revAddrSlice[i])
+			return nil
 		}
 
-		// Construct back IPv4 into LE
-		addr := strings.Join(addrSlice, ".")
+		addr := ptrIPv4(question)
 		f.fwl.Infof(infoPTRIPv4, question, addr, strings.Join(answerSlice, ""))
 
-		err := f.authIPv4(question, addr)
+		err := f.authIP(question, addr, clientAddr, ttl)
 		if err != nil {
 			f.fwl.Error(err)
 		}
@@ -114,8 +141,106 @@ func (f *Authorizer) HandleRequest(resp *dns.Msg) error {
 	return nil
 }
 
-func (f *Authorizer) authIPv4(question, ip string) error {
-	blacklisted, err := f.checkIPv4Blacklist(ip)
+// RecordRequest records the question of resp and client for the hosts in resp that are
+// already authorized, without changing the firewall or the hosts' expiry. It is meant for
+// clients that shared an answer that was passed to HandleRequest for another client
+func (f *Authorizer) RecordRequest(resp *dns.Msg, client net.IP) {
+	if f.cache == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Question) == 0 {
+		return
+	}
+
+	question := resp.Question[0].Name
+
+	var clientAddr string
+	if client != nil {
+		clientAddr = client.String()
+	}
+
+	for _, ip := range answerHosts(resp) {
+		f.cache.Record(ip, question, clientAddr)
+	}
+}
+
+// answerHosts returns the hosts HandleRequest authorizes for resp: the addresses of A and
+// AAAA answers, or the address of a PTR question
+func answerHosts(resp *dns.Msg) []string {
+	hosts := []string{}
+	question := resp.Question[0].Name
+
+	switch resp.Question[0].Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		for _, answer := range resp.Answer {
+			switch r := answer.(type) {
+			case *dns.A:
+				hosts = append(hosts, r.A.String())
+			case *dns.AAAA:
+				hosts = append(hosts, r.AAAA.String())
+			}
+		}
+	case dns.TypePTR:
+		if len(resp.Answer) == 0 {
+			break
+		}
+
+		if t := strings.Split(question, ".arpa")[0]; strings.HasSuffix(t, ".ip6") {
+			if addr, err := ptrIPv6(strings.TrimSuffix(t, ".ip6")); err == nil {
+				hosts = append(hosts, addr)
+			}
+			break
+		}
+		hosts = append(hosts, ptrIPv4(question))
+	}
+
+	return hosts
+}
+
+// ptrIPv4 converts an in-addr.arpa name back into an IPv4 address
+func ptrIPv4(question string) string {
+	// Split PTR Question BE IPv4 string
+	revAddr := strings.Split(question, ".in-addr.arpa")[0]
+
+	// Split BE IPv4 string into a slice
+	revAddrSlice := strings.Split(revAddr, ".")
+	addrSlice := []string{}
+
+	// Convert to LE
+	for i := len(revAddrSlice) - 1; i >= 0; i-- {
+		addrSlice = append(addrSlice, revAddrSlice[i])
+	}
+
+	// Construct back IPv4 into LE
+	return strings.Join(addrSlice, ".")
+}
+
+// ptrIPv6 converts the nibbles of an ip6.arpa name (without the ip6.arpa suffix) back into an IPv6 address
+func ptrIPv6(nibbles string) (string, error) {
+	revNibbles := strings.Split(nibbles, ".")
+	if len(revNibbles) != 32 {
+		return "", fmt.Errorf(errPTRIPv6, nibbles)
+	}
+
+	// Convert to LE and group the nibbles into 16bit fields
+	var addr strings.Builder
+	for i := len(revNibbles) - 1; i >= 0; i-- {
+		addr.WriteString(revNibbles[i])
+		if i%4 == 0 && i > 0 {
+			addr.WriteString(":")
+		}
+	}
+
+	ip := net.ParseIP(addr.String())
+	if ip == nil {
+		return "", fmt.Errorf(errPTRIPv6, nibbles)
+	}
+
+	return ip.String(), nil
+}
+
+// authIP authorizes an IPv4 or IPv6 host in the authorized set of its family and records
+// the question and the client in the host's record. ttl is the TTL of the answer record,
+// the host's lifetime is based on it in answer ttl mode
+func (f *Authorizer) authIP(question, ip, client string, ttl uint32) error {
+	blacklisted, err := f.checkBlacklist(ip)
 	if err != nil {
 		return err
 	}
@@ -125,32 +250,34 @@ func (f *Authorizer) authIPv4(question, ip string) error {
 		return nil
 	}
 
-	if ip == "0.0.0.0" {
+	if netIP := net.ParseIP(ip); netIP == nil || netIP.IsUnspecified() {
 		f.fwl.Infof(infoAuthBlock, question)
 		return nil
 	}
 
-	regOK := f.cache.Register(ip)
+	lifetime := f.lifetime(ttl)
+	regOK := f.cache.Register(ip, lifetime)
 	if !regOK {
-		f.cache.Renew(ip)
+		f.cache.Renew(ip, lifetime)
+		f.cache.Record(ip, question, client)
 		f.fwl.Infof(infoAuthExists, question, ip)
 		return nil
 	}
 
-	err = f.fw.AddIPv4ToSetRule(f.authorizedSet, ip)
+	err = f.fw.AddToSetRule(f.authorizedSet, ip)
 	if err != nil {
 		return err
 	}
+	f.cache.Record(ip, question, client)
 	f.fwl.Infof(infoAuth, question, ip)
 
 	return nil
 }
 
-func (f *Authorizer) checkIPv4Blacklist(ip string) (bool, error) {
-	// unsafe function, we are not checking string input for valid
-	// ip address. We should add a check here
+func (f *Authorizer) checkBlacklist(ip string) (bool, error) {
+	netIP := net.ParseIP(ip)
 	for _, j := range f.blacklistHosts {
-		if ip == j {
+		if netIP.Equal(net.ParseIP(j)) {
 			return true, nil
 		}
 	}
@@ -161,7 +288,7 @@ func (f *Authorizer) checkIPv4Blacklist(ip string) (bool, error) {
 			return false, err
 		}
 
-		if netj.Contains(net.ParseIP(ip)) {
+		if netj.Contains(netIP) {
 			return true, nil
 		}
 	}
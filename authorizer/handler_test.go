@@ -0,0 +1,149 @@
+package authorizer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPTRIPv6(t *testing.T) {
+	tests := []struct {
+		name    string
+		nibbles string
+		addr    string
+		err     bool
+	}{
+		{
+			name:    "full address",
+			nibbles: "4.3.2.1.8.7.6.5.c.b.a.9.0.f.e.d.4.3.2.1.8.7.6.5.c.b.a.9.0.f.e.d",
+			addr:    "def0:9abc:5678:1234:def0:9abc:5678:1234",
+		},
+		{
+			name:    "compressed result",
+			nibbles: "2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2",
+			addr:    "2001:db8::2",
+		},
+		{
+			name:    "loopback",
+			nibbles: "1" + strings.Repeat(".0", 31),
+			addr:    "::1",
+		},
+		{
+			name:    "uppercase nibbles",
+			nibbles: "2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.B.D.0.1.0.0.2",
+			addr:    "2001:db8::2",
+		},
+		{
+			name:    "too few nibbles",
+			nibbles: "2.0.0.0.8.b.d.0.1.0.0.2",
+			err:     true,
+		},
+		{
+			name:    "too many nibbles",
+			nibbles: strings.Repeat("0.", 32) + "1",
+			err:     true,
+		},
+		{
+			name:    "invalid hex",
+			nibbles: "g" + strings.Repeat(".0", 31),
+			err:     true,
+		},
+		{
+			name:    "empty",
+			nibbles: "",
+			err:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := ptrIPv6(tt.nibbles)
+			if (err != nil) != tt.err {
+				t.Fatalf("ptrIPv6() error = %v, want error %v", err, tt.err)
+			}
+			if addr != tt.addr {
+				t.Errorf("ptrIPv6() = %q, want %q", addr, tt.addr)
+			}
+		})
+	}
+}
+
+func TestPTRIPv4(t *testing.T) {
+	tests := []struct {
+		question, addr string
+	}{
+		{"20.1.168.192.in-addr.arpa.", "192.168.1.20"},
+		{"1.0.0.127.in-addr.arpa", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := ptrIPv4(tt.question); got != tt.addr {
+			t.Errorf("ptrIPv4(%q) = %q, want %q", tt.question, got, tt.addr)
+		}
+	}
+}
+
+func TestAnswerHosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		answer []string
+		hosts  []string
+	}{
+		{
+			name:   "a and aaaa records",
+			qname:  "example.com.",
+			qtype:  dns.TypeA,
+			answer: []string{"example.com. 60 IN CNAME cdn.example.com.", "cdn.example.com. 60 IN A 192.0.2.1", "cdn.example.com. 60 IN AAAA 2001:db8::1"},
+			hosts:  []string{"192.0.2.1", "2001:db8::1"},
+		},
+		{
+			name:   "ipv4 ptr",
+			qname:  "1.2.0.192.in-addr.arpa.",
+			qtype:  dns.TypePTR,
+			answer: []string{"1.2.0.192.in-addr.arpa. 60 IN PTR host.example.com."},
+			hosts:  []string{"192.0.2.1"},
+		},
+		{
+			name:   "ipv6 ptr",
+			qname:  "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+			qtype:  dns.TypePTR,
+			answer: []string{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 60 IN PTR host.example.com."},
+			hosts:  []string{"2001:db8::1"},
+		},
+		{
+			name:  "ptr without answer",
+			qname: "1.2.0.192.in-addr.arpa.",
+			qtype: dns.TypePTR,
+			hosts: []string{},
+		},
+		{
+			name:   "other types",
+			qname:  "example.com.",
+			qtype:  dns.TypeTXT,
+			answer: []string{`example.com. 60 IN TXT "text"`},
+			hosts:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := new(dns.Msg)
+			resp.SetQuestion(tt.qname, tt.qtype)
+			for _, s := range tt.answer {
+				rr, err := dns.NewRR(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Answer = append(resp.Answer, rr)
+			}
+
+			if hosts := answerHosts(resp); !reflect.DeepEqual(hosts, tt.hosts) {
+				t.Errorf("answerHosts() = %q, want %q", hosts, tt.hosts)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package authorizer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ulfox/nettrust/authorizer/cache"
+)
+
+// Host returns the record of an authorized host: the domains that resolved to it, the clients
+// that asked for them, the number of queries, when it was first and last seen and when it expires
+func (f *Authorizer) Host(ip string) (cache.Host, bool) {
+	return f.cache.Get(ip)
+}
+
+// Hosts returns the records of all authorized hosts
+func (f *Authorizer) Hosts() map[string]cache.Host {
+	return f.cache.List()
+}
+
+// LogHosts logs the records of all authorized hosts, sorted by address
+func (f *Authorizer) LogHosts() {
+	l := f.logger.WithFields(logrus.Fields{
+		"Component": "Firewall",
+		"Stage":     "Hosts",
+	})
+
+	hosts := f.cache.List()
+	ips := make([]string, 0, len(hosts))
+	for ip := range hosts {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	for _, ip := range ips {
+		h := hosts[ip]
+		l.Infof(
+			infoHostRecord,
+			ip,
+			countList(h.Domains),
+			countList(h.Clients),
+			h.Queries,
+			timeOrNever(h.FirstSeen),
+			timeOrNever(h.LastSeen),
+			timeOrNever(h.Expires),
+		)
+	}
+	l.Infof(infoHostRecords, len(hosts))
+}
+
+// countList formats a name to count map as a sorted list of name(count)
+func countList(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for n, c := range counts {
+		names = append(names, fmt.Sprintf("%s(%d)", n, c))
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, " ")
+}
+
+// timeOrNever formats a record time. Hosts imported from the firewall on start have not
+// been seen in a query and hosts without an expiry never expire
+func timeOrNever(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return t.Format(time.RFC3339)
+}
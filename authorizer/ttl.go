@@ -0,0 +1,57 @@
+package authorizer
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// TTLModeFixed expires authorized hosts after the authorized ttl
+	TTLModeFixed = "fixed"
+	// TTLModeAnswer expires authorized hosts based on the TTL of the answer record that authorized them
+	TTLModeAnswer = "answer"
+)
+
+// AnswerTTL bounds the lifetime of authorized hosts in answer ttl mode. The TTL of the answer record
+// is raised to Min and lowered to Max seconds, Grace seconds are added to it so that clients that use
+// the answer until its TTL runs out can finish their connections
+type AnswerTTL struct {
+	Min, Max, Grace int
+}
+
+func checkTTLMode(mode string, answerTTL AnswerTTL) error {
+	switch mode {
+	case TTLModeFixed:
+		return nil
+	case TTLModeAnswer:
+	default:
+		return fmt.Errorf(errTTLMode, mode)
+	}
+
+	if answerTTL.Min < 0 || answerTTL.Grace < 0 || answerTTL.Max < 1 || answerTTL.Max < answerTTL.Min {
+		return fmt.Errorf(errAnswerTTL, answerTTL.Min, answerTTL.Max, answerTTL.Grace)
+	}
+
+	return nil
+}
+
+// lifetime returns how long a host from an answer record with ttl stays authorized.
+// A lifetime of 0 never expires
+func (f *Authorizer) lifetime(ttl uint32) time.Duration {
+	if f.ttlMode == TTLModeFixed {
+		if f.ttl < 0 {
+			return 0
+		}
+		return time.Duration(f.ttl) * time.Second
+	}
+
+	seconds := int64(ttl)
+	if seconds < int64(f.answerTTL.Min) {
+		seconds = int64(f.answerTTL.Min)
+	}
+	if seconds > int64(f.answerTTL.Max) {
+		seconds = int64(f.answerTTL.Max)
+	}
+
+	return time.Duration(seconds+int64(f.answerTTL.Grace)) * time.Second
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"net"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/ulfox/nettrust/authorizer"
 	"github.com/ulfox/nettrust/dns"
+	"github.com/ulfox/nettrust/dns/zones"
 	"github.com/ulfox/nettrust/firewall"
 
 	"github.com/ulfox/nettrust/core"
@@ -16,6 +20,8 @@ const (
 	chainNameOutput = "authorized-output"
 	authorizedSet   = "authorized"
 	chainNameInput  = "input"
+	chainNameBan    = "banned-input"
+	bannedSet       = "banned"
 )
 
 var (
@@ -52,19 +58,114 @@ func main() {
 		log.Warn("on exit NetTrust will not flush the authorized hosts list")
 	}
 
+	forwardRules := []dns.ForwardRule{}
+	for _, r := range config.ForwardRules {
+		forwardRules = append(forwardRules, dns.ForwardRule{
+			Domains:     r.Domains,
+			Upstreams:   dnsUpstreams(r.Upstreams),
+			Strategy:    r.Strategy,
+			PassThrough: r.PassThrough,
+		})
+	}
+
+	listeners := []dns.Listener{}
+	for _, l := range config.Listeners {
+		listeners = append(listeners, dns.Listener{
+			Addr:         l.Addr,
+			Proto:        l.Proto,
+			Cert:         l.Cert,
+			CertKey:      l.CertKey,
+			ClientCaCert: l.ClientCaCert,
+			ClientAuth:   l.ClientAuth,
+			FilterAAAA:   l.FilterAAAA,
+		})
+	}
+
+	// AAAA answers are authorized with ipv6, there is nothing to filter
+	filterAAAA := dns.FilterAAAA{Mode: config.FilterAAAA}
+	for _, r := range config.FilterAAAARules {
+		filterAAAA.Rules = append(filterAAAA.Rules, dns.FilterAAAARule{
+			Domains: r.Domains,
+			Mode:    r.Mode,
+		})
+	}
+	if config.IPv6 {
+		filtered := filterAAAA.Mode != "off" || len(filterAAAA.Rules) > 0
+		for i := range listeners {
+			if listeners[i].FilterAAAA != "" && listeners[i].FilterAAAA != "off" {
+				filtered = true
+			}
+			listeners[i].FilterAAAA = ""
+		}
+		if filtered {
+			log.Warn(core.WarnFilterAAAAIPv6)
+		}
+		filterAAAA = dns.FilterAAAA{}
+	}
+
+	blocklists := []dns.Blocklist{}
+	for _, b := range config.Blacklist.Lists {
+		blocklists = append(blocklists, dns.Blocklist{
+			Source: b.Source,
+			Format: b.Format,
+		})
+	}
+
+	localZones := []zones.Zone{}
+	for _, z := range config.Zones {
+		localZones = append(localZones, zones.Zone{
+			Origin:  z.Origin,
+			File:    z.File,
+			Records: z.Records,
+		})
+	}
+
 	// DNS Server
 	dnsServer, err := dns.NewDNSServer(
-		config.ListenAddr,
-		config.FWDAddr,
-		config.FWDProto,
-		config.ListenCert,
-		config.ListenCertKey,
-		config.FWDCaCert,
-		config.ListenTLS,
-		config.FWDTLS,
+		listeners,
+		dns.ClientAccess{
+			Allow:        config.ClientsAllow,
+			Deny:         config.ClientsDeny,
+			Authorize:    config.ClientsAuthorize,
+			DenyResponse: config.ClientsDenyResponse,
+		},
+		dns.RateLimit{
+			QPS:          config.RateLimitQPS,
+			Burst:        config.RateLimitBurst,
+			StrikeLimit:  config.StrikeLimit,
+			StrikeWindow: config.StrikeWindow,
+			BanTime:      config.BanTime,
+			RRL:          config.RRLResponses,
+			RRLSlip:      config.RRLSlip,
+		},
+		dnsUpstreams(config.Upstreams),
+		config.FWDStrategy,
+		dns.UpstreamHealth{
+			ProbeInterval: config.UpstreamProbeInterval,
+			FailThreshold: config.UpstreamFailThreshold,
+			Cooldown:      config.UpstreamCooldown,
+			ProbeName:     config.UpstreamProbeName,
+		},
+		forwardRules,
 		config.DNSTTLCache,
+		config.DNSCacheMaxEntries,
+		config.DNSCacheMaxBytes,
+		config.DNSCacheServeStale,
+		config.DNSCachePrefetch,
+		config.DNSCacheFile,
+		config.DNSCacheSaveInterval,
 		config.FWDUDPBufferSize,
+		config.ListenUDPBufferSize,
 		config.Blacklist.Domains,
+		blocklists,
+		config.BlocklistRefresh,
+		config.BlockedResponse,
+		config.BlockedSinkhole,
+		config.Whitelist.Domains,
+		config.DomainWhitelistMode,
+		filterAAAA,
+		localZones,
+		config.ZonesAuthorize,
 		logger,
 	)
 	if err != nil {
@@ -78,6 +179,7 @@ func main() {
 		tableNameOutput,
 		chainNameOutput,
 		config.FirewallDropInput,
+		config.IPv6,
 		logger,
 	)
 	if err != nil {
@@ -91,9 +193,49 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Banned clients are dropped in the firewall until their ban expires
+	if config.BanFirewall {
+		err = fw.AddIPv4BanSet(chainNameBan, bannedSet)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if config.IPv6 {
+			err = fw.AddIPv6BanSet(chainNameBan, bannedSet)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		banLog := logger.WithFields(logrus.Fields{
+			"Component": "Firewall",
+			"Stage":     "Ban",
+		})
+		// Whitelisted hosts and networks are never banned in the firewall
+		banExempt := append(append([]string{}, config.Whitelist.Networks...), config.Whitelist.Hosts...)
+		for k, v := range config.Env {
+			if strings.HasPrefix(k, "whitelist.networks") || strings.HasPrefix(k, "whitelist.hosts") {
+				banExempt = append(banExempt, v)
+			}
+		}
+
+		err = dnsServer.SetBanHandler(func(ip net.IP, d time.Duration) {
+			if ip.To4() == nil && !config.IPv6 {
+				return
+			}
+			err := fw.Ban(bannedSet, ip.String(), d)
+			if err != nil {
+				banLog.Error(err)
+			}
+		}, banExempt)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	for k, v := range config.Env {
 		if strings.HasPrefix(k, "blacklist.networks") {
-			err = core.CheckIPV4Network(v)
+			err = core.CheckIPNetwork(v, config.IPv6)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -103,7 +245,7 @@ func main() {
 
 	for k, v := range config.Env {
 		if strings.HasPrefix(k, "blacklist.hosts") {
-			err = core.CheckIPV4Addresses(v)
+			err = core.CheckIPAddresses(v, config.IPv6)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -113,6 +255,12 @@ func main() {
 
 	authorizer, cacheContext, err := authorizer.NewAuthorizer(
 		config.AuthorizedTTL,
+		config.AuthorizedTTLMode,
+		authorizer.AnswerTTL{
+			Min:   config.AuthorizedTTLMin,
+			Max:   config.AuthorizedTTLMax,
+			Grace: config.AuthorizedTTLGrace,
+		},
 		config.TTLCheckTicker,
 		authorizedSet,
 		config.Blacklist.Hosts,
@@ -125,22 +273,70 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Init DNS Servers
-	udpDNSServerContext := dnsServer.UDPListenBackground(
-		authorizer.HandleRequest)
-	tcpDNSServerContext := dnsServer.TCPListenBackground(
+	// Init DNS Servers. Clients that share an answer with another query
+	// are only recorded, the answer is authorized once
+	dnsServer.SetRecordHandler(authorizer.RecordRequest)
+	dnsServerContexts := dnsServer.ListenBackground(
 		authorizer.HandleRequest)
+	blocklistsContext := dnsServer.BlocklistsBackground()
+	healthContext := dnsServer.HealthBackground()
+	certsContext := dnsServer.CertsBackground()
+
+	// Listeners that stop on their own (e.g. the address is in use)
+	// report their error here, NetTrust then shuts down
+	listenerErrs := make(chan error, len(dnsServerContexts))
+	for _, c := range dnsServerContexts {
+		go func(c *dns.ServiceContext) {
+			if err, ok := <-c.Err(); ok {
+				listenerErrs <- err
+			}
+		}(c)
+	}
 
 	sysSigs := core.NewOSSignal()
 
-	sysSigs.Wait()
-	log.Infof("Interrupted")
+	hostsSigs := core.NewHostsSignal()
+	go func() {
+		for range hostsSigs.Signal {
+			authorizer.LogHosts()
+		}
+	}()
+
+	upstreamsSigs := core.NewUpstreamsSignal()
+	go func() {
+		for range upstreamsSigs.Signal {
+			dnsServer.LogUpstreamStatus()
+		}
+	}()
+
+	var exitErr error
+	select {
+	case <-sysSigs.Signal:
+		log.Infof("Interrupted")
+	case exitErr = <-listenerErrs:
+		log.Errorf("%s, nettrust is shutting down", exitErr)
+	}
+
+	for _, c := range dnsServerContexts {
+		c.Expire()
+	}
+
+	for _, c := range dnsServerContexts {
+		c.Wait()
+	}
+
+	// The dns cache is shared by all listeners, it is stopped and saved
+	// only after all of them have stopped
+	dnsServer.Close()
+
+	blocklistsContext.Expire()
+	blocklistsContext.Wait()
 
-	udpDNSServerContext.Expire()
-	tcpDNSServerContext.Expire()
+	healthContext.Expire()
+	healthContext.Wait()
 
-	udpDNSServerContext.Wait()
-	tcpDNSServerContext.Wait()
+	certsContext.Expire()
+	certsContext.Wait()
 
 	cacheContext.Expire()
 	cacheContext.Wait()
@@ -162,36 +358,66 @@ func main() {
 				log.Fatal(err)
 			}
 		}
+		if config.BanFirewall {
+			err = fw.DeleteChain(chainNameBan)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
 		err = fw.DeleteTable(tableNameOutput)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
+	if exitErr != nil {
+		os.Exit(1)
+	}
+}
+
+func dnsUpstreams(upstreams []core.Upstream) []dns.Upstream {
+	dnsUpstreams := []dns.Upstream{}
+	for _, u := range upstreams {
+		dnsUpstreams = append(dnsUpstreams, dns.Upstream{
+			Addr:       u.Addr,
+			Proto:      u.Proto,
+			TLS:        u.TLS,
+			CaCert:     u.CaCert,
+			ClientCert: u.ClientCert,
+			ClientKey:  u.ClientKey,
+			Path:       u.Path,
+			Method:     u.Method,
+			ServerName: u.ServerName,
+			Timeout:    u.Timeout,
+			Retries:    u.Retries,
+		})
+	}
+
+	return dnsUpstreams
 }
 
 func makeDefaultRules(fw *firewall.Firewall, config *core.NetTrust) error {
 	var err error
 
 	for _, v := range config.WhitelistLo {
-		err = core.CheckIPV4Network(v)
+		err = core.CheckIPNetwork(v, config.IPv6)
 		if err != nil {
 			return err
 		}
 
-		err = fw.AddIPv4NetworkRule(v)
+		err = fw.AddNetworkRule(v)
 		if err != nil {
 			return err
 		}
 	}
 
 	for _, v := range config.WhitelistPrivate {
-		err = core.CheckIPV4Network(v)
+		err = core.CheckIPNetwork(v, config.IPv6)
 		if err != nil {
 			return err
 		}
 
-		err = fw.AddIPv4NetworkRule(v)
+		err = fw.AddNetworkRule(v)
 		if err != nil {
 			return err
 		}
@@ -199,12 +425,12 @@ func makeDefaultRules(fw *firewall.Firewall, config *core.NetTrust) error {
 
 	for k, v := range config.Env {
 		if strings.HasPrefix(k, "whitelist.networks") {
-			err = core.CheckIPV4Network(v)
+			err = core.CheckIPNetwork(v, config.IPv6)
 			if err != nil {
 				return err
 			}
 
-			err = fw.AddIPv4NetworkRule(v)
+			err = fw.AddNetworkRule(v)
 			if err != nil {
 				return err
 			}
@@ -212,34 +438,42 @@ func makeDefaultRules(fw *firewall.Firewall, config *core.NetTrust) error {
 	}
 
 	for _, v := range config.Whitelist.Networks {
-		err = core.CheckIPV4Network(v)
+		err = core.CheckIPNetwork(v, config.IPv6)
 		if err != nil {
 			return err
 		}
 
-		err = fw.AddIPv4NetworkRule(v)
+		err = fw.AddNetworkRule(v)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = fw.AddIPv4Set("whitelist")
+	err = addSet(fw, "whitelist", config.IPv6)
 	if err != nil {
 		return err
 	}
 
-	err = fw.AddIPv4SetRule("whitelist")
-	if err != nil {
-		return err
+	whitelistAddrs := map[string]struct{}{}
+	for _, l := range config.Listeners {
+		whitelistAddrs[l.Addr] = struct{}{}
+	}
+	for _, u := range config.AllUpstreams() {
+		whitelistAddrs[u.Addr] = struct{}{}
 	}
 
-	for _, n := range []string{config.ListenAddr, config.FWDAddr} {
-		err = core.CheckIPV4SocketAddress(n)
+	for n := range whitelistAddrs {
+		err = core.CheckIPSocketAddress(n)
 		if err != nil {
 			return err
 		}
 
-		err = fw.AddIPv4ToSetRule("whitelist", strings.Split(n, ":")[0])
+		host, _, _ := net.SplitHostPort(n)
+		if net.ParseIP(host).To4() == nil && !config.IPv6 {
+			continue
+		}
+
+		err = fw.AddToSetRule("whitelist", host)
 		if err != nil {
 			return err
 		}
@@ -247,12 +481,12 @@ func makeDefaultRules(fw *firewall.Firewall, config *core.NetTrust) error {
 
 	for k, v := range config.Env {
 		if strings.HasPrefix(k, "whitelist.hosts") {
-			err = core.CheckIPV4Addresses(v)
+			err = core.CheckIPAddresses(v, config.IPv6)
 			if err != nil {
 				return err
 			}
 
-			err = fw.AddIPv4ToSetRule("whitelist", v)
+			err = fw.AddToSetRule("whitelist", v)
 			if err != nil {
 				return err
 			}
@@ -260,23 +494,32 @@ func makeDefaultRules(fw *firewall.Firewall, config *core.NetTrust) error {
 	}
 
 	for _, v := range config.Whitelist.Hosts {
-		err = core.CheckIPV4Addresses(v)
+		err = core.CheckIPAddresses(v, config.IPv6)
 		if err != nil {
 			return err
 		}
 
-		err = fw.AddIPv4ToSetRule("whitelist", v)
+		err = fw.AddToSetRule("whitelist", v)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = fw.AddIPv4Set(authorizedSet)
-	if err != nil {
-		return err
+	// Clients connect to the sinkhole when a blacklisted domain is answered with it
+	if config.BlockedResponse == dns.BlockedSinkhole {
+		for _, v := range config.BlockedSinkhole {
+			if core.CheckIPAddresses(v, config.IPv6) != nil {
+				continue
+			}
+
+			err = fw.AddToSetRule("whitelist", v)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	err = fw.AddIPv4SetRule(authorizedSet)
+	err = addSet(fw, authorizedSet, config.IPv6)
 	if err != nil {
 		return err
 	}
@@ -286,5 +529,36 @@ func makeDefaultRules(fw *firewall.Firewall, config *core.NetTrust) error {
 		return err
 	}
 
+	if config.IPv6 {
+		err = fw.AddIPv6TailingReject()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// addSet creates a set and the rule that accepts traffic to its hosts, in the ip6 table also if ipv6 is set
+func addSet(fw *firewall.Firewall, n string, ipv6 bool) error {
+	err := fw.AddIPv4Set(n)
+	if err != nil {
+		return err
+	}
+
+	err = fw.AddIPv4SetRule(n)
+	if err != nil {
+		return err
+	}
+
+	if !ipv6 {
+		return nil
+	}
+
+	err = fw.AddIPv6Set(n)
+	if err != nil {
+		return err
+	}
+
+	return fw.AddIPv6SetRule(n)
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -82,3 +83,77 @@ func CheckIPV4Network(addr string) error {
 
 	return nil
 }
+
+// CheckIPV6Addresses simple method for checking if an address is a correct
+// ipv6 address
+func CheckIPV6Addresses(addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return fmt.Errorf(errNotValidIPv6Addr, addr)
+	}
+
+	return nil
+}
+
+// CheckIPV6Network simple method for checking if a network is a correct
+// ipv6 network
+func CheckIPV6Network(addr string) error {
+	ip, _, err := net.ParseCIDR(addr)
+	if err != nil || ip.To4() != nil {
+		return fmt.Errorf(errNotValidIPv6Network, addr)
+	}
+
+	return nil
+}
+
+// CheckIPAddresses checks if an address is a correct ipv4 address, or a correct
+// ipv6 address when ipv6 is enabled
+func CheckIPAddresses(addr string, ipv6 bool) error {
+	if !strings.Contains(addr, ":") {
+		return CheckIPV4Addresses(addr)
+	}
+
+	if !ipv6 {
+		return fmt.Errorf(errIPv6Disabled, addr)
+	}
+
+	return CheckIPV6Addresses(addr)
+}
+
+// CheckIPNetwork checks if a network is a correct ipv4 network, or a correct
+// ipv6 network when ipv6 is enabled
+func CheckIPNetwork(addr string, ipv6 bool) error {
+	if !strings.Contains(addr, ":") {
+		return CheckIPV4Network(addr)
+	}
+
+	if !ipv6 {
+		return fmt.Errorf(errIPv6Disabled, addr)
+	}
+
+	return CheckIPV6Network(addr)
+}
+
+// CheckIPSocketAddress checks if input strings can be split into ip/port pairs. IPv6
+// addresses are expected in brackets, e.g. [::1]:53
+func CheckIPSocketAddress(address string) error {
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf(errInvalidSocketAddress, address)
+	}
+
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return err
+	}
+
+	if port < 1 || port > 65535 {
+		return fmt.Errorf(errInvalidPort, port)
+	}
+
+	if strings.Contains(host, ":") {
+		return CheckIPV6Addresses(host)
+	}
+
+	return CheckIPV4Addresses(host)
+}
@@ -6,19 +6,83 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
+// Upstream for describing a forward dns server. If proto is left empty, fwdProto is used.
+// Path, Method and ServerName are used only when proto is https (DoH). Timeout is in milliseconds.
+// ClientCert and ClientKey are presented to DoT and DoH upstreams that require client certificates
+type Upstream struct {
+	Addr       string `json:"addr"`
+	Proto      string `json:"proto"`
+	TLS        bool   `json:"tls"`
+	CaCert     string `json:"caCert"`
+	ClientCert string `json:"clientCert"`
+	ClientKey  string `json:"clientKey"`
+	Path       string `json:"path"`
+	Method     string `json:"method"`
+	ServerName string `json:"serverName"`
+	Timeout    int    `json:"timeout"`
+	Retries    int    `json:"retries"`
+}
+
+// ForwardRule for forwarding queries for names under Domains to a different set of upstreams.
+// If passThrough is true, answers are sent to the client without being authorized in the firewall
+type ForwardRule struct {
+	Domains     []string   `json:"domains"`
+	Upstreams   []Upstream `json:"upstreams"`
+	Strategy    string     `json:"strategy"`
+	PassThrough bool       `json:"passThrough"`
+}
+
+// Blocklist for describing a domain blocklist. Source is either a local file or an http(s) url.
+// Format can be auto (default), hosts, domains or adblock
+type Blocklist struct {
+	Source string `json:"source"`
+	Format string `json:"format"`
+}
+
+// Zone for describing a local zone. Records are given in zone file format. If origin is set,
+// NetTrust answers NXDOMAIN for names under origin that have no local records
+type Zone struct {
+	Origin  string   `json:"origin"`
+	File    string   `json:"file"`
+	Records []string `json:"records"`
+}
+
+// Listener for describing a NetTrust listen endpoint. Proto can be udp, tcp, dot or doh.
+// If cert or certKey are left empty, listenCert and listenCertKey are used. The same applies
+// to clientCaCert and clientAuth, which configure client certificate authentication for dot and doh.
+// FilterAAAA overrides filterAAAA for queries received on the listener
+type Listener struct {
+	Addr         string `json:"addr"`
+	Proto        string `json:"proto"`
+	Cert         string `json:"cert"`
+	CertKey      string `json:"certKey"`
+	ClientCaCert string `json:"clientCaCert"`
+	ClientAuth   string `json:"clientAuth"`
+	FilterAAAA   string `json:"filterAAAA"`
+}
+
+// FilterAAAARule for setting the filter AAAA mode (off, strip, nodata) for names under Domains
+type FilterAAAARule struct {
+	Domains []string `json:"domains"`
+	Mode    string   `json:"mode"`
+}
+
 // NetTrust for reading either NET_TRUST env into a map or a config file into a map
 type NetTrust struct {
 	Whitelist struct {
 		Networks []string `json:"networks"`
 		Hosts    []string `json:"hosts"`
+		Domains  []string `json:"domains"`
 	} `json:"whitelist"`
 	Blacklist struct {
-		Networks []string `json:"networks"`
-		Hosts    []string `json:"hosts"`
-		Domains  []string `json:"domains"`
+		Networks []string    `json:"networks"`
+		Hosts    []string    `json:"hosts"`
+		Domains  []string    `json:"domains"`
+		Lists    []Blocklist `json:"lists"`
 	} `json:"blacklist"`
 	Env                       map[string]string
 	DoNotFlushTable           bool   `json:"doNotFlushTable"`
@@ -28,8 +92,10 @@ type NetTrust struct {
 	FWDTLS                    bool   `json:"fwdTLS"`
 	FWDCaCert                 string `json:"fwdCaCert"`
 	FWDUDPBufferSize          uint16 `json:"fwdUDPBufferSize"`
+	FWDStrategy               string `json:"fwdStrategy"`
 	ListenAddr                string `json:"listenAddr"`
 	ListenTLS                 bool   `json:"listenTLS"`
+	ListenDoHAddr             string `json:"listenDoHAddr"`
 	ListenCert                string `json:"listenCert"`
 	ListenCertKey             string `json:"listenCertKey"`
 	FirewallBackend           string `json:"firewallBackend"`
@@ -42,6 +108,65 @@ type NetTrust struct {
 	AuthorizedTTL             int `json:"ttl"`
 	TTLCheckTicker            int `json:"ttlInterval"`
 	DNSTTLCache               int `json:"dnsTTLCache"`
+
+	Upstreams    []Upstream    `json:"upstreams"`
+	ForwardRules []ForwardRule `json:"forwardRules"`
+	Listeners    []Listener    `json:"listeners"`
+
+	DomainWhitelistMode string `json:"domainWhitelistMode"`
+	BlocklistRefresh    int    `json:"blocklistRefresh"`
+
+	Zones          []Zone `json:"zones"`
+	ZonesAuthorize bool   `json:"zonesAuthorize"`
+
+	DNSCacheMaxEntries int `json:"dnsCacheMaxEntries"`
+	DNSCacheMaxBytes   int `json:"dnsCacheMaxBytes"`
+	DNSCacheServeStale int `json:"dnsCacheServeStale"`
+	DNSCachePrefetch   int `json:"dnsCachePrefetch"`
+
+	DNSCacheFile         string `json:"dnsCacheFile"`
+	DNSCacheSaveInterval int    `json:"dnsCacheSaveInterval"`
+
+	FWDTimeout            int    `json:"fwdTimeout"`
+	FWDRetries            int    `json:"fwdRetries"`
+	UpstreamProbeInterval int    `json:"upstreamProbeInterval"`
+	UpstreamFailThreshold int    `json:"upstreamFailThreshold"`
+	UpstreamCooldown      int    `json:"upstreamCooldown"`
+	UpstreamProbeName     string `json:"upstreamProbeName"`
+
+	ListenUDPBufferSize uint16 `json:"listenUDPBufferSize"`
+
+	FWDClientCert      string `json:"fwdClientCert"`
+	FWDClientKey       string `json:"fwdClientKey"`
+	ListenClientCaCert string `json:"listenClientCaCert"`
+	ListenClientAuth   string `json:"listenClientAuth"`
+
+	BlockedResponse string   `json:"blockedResponse"`
+	BlockedSinkhole []string `json:"blockedSinkhole"`
+
+	ClientsAllow        []string `json:"clientsAllow"`
+	ClientsDeny         []string `json:"clientsDeny"`
+	ClientsDenyResponse string   `json:"clientsDenyResponse"`
+	ClientsAuthorize    []string `json:"clientsAuthorize"`
+
+	RateLimitQPS   int  `json:"rateLimitQPS"`
+	RateLimitBurst int  `json:"rateLimitBurst"`
+	StrikeLimit    int  `json:"strikeLimit"`
+	StrikeWindow   int  `json:"strikeWindow"`
+	BanTime        int  `json:"banTime"`
+	BanFirewall    bool `json:"banFirewall"`
+	RRLResponses   int  `json:"rrlResponses"`
+	RRLSlip        int  `json:"rrlSlip"`
+
+	IPv6 bool `json:"ipv6"`
+
+	FilterAAAA      string           `json:"filterAAAA"`
+	FilterAAAARules []FilterAAAARule `json:"filterAAAARules"`
+
+	AuthorizedTTLMode  string `json:"ttlMode"`
+	AuthorizedTTLMin   int    `json:"ttlMin"`
+	AuthorizedTTLMax   int    `json:"ttlMax"`
+	AuthorizedTTLGrace int    `json:"ttlGrace"`
 }
 
 // GetNetTrustEnv will read environ and create a map of k:v from envs
@@ -110,15 +235,117 @@ func GetNetTrustEnv() (*NetTrust, error) {
 		config.FWDCaCert = *fwdTLSCert
 	}
 
-	if config.FWDTLS && config.FWDCaCert != "" {
-		err = fileExists(*fwdTLSCert)
+	if *fwdClientCert != "" {
+		config.FWDClientCert = *fwdClientCert
+	}
+
+	if *fwdClientKey != "" {
+		config.FWDClientKey = *fwdClientKey
+	}
+
+	if *fwdStrategy != "" {
+		config.FWDStrategy = *fwdStrategy
+	}
+
+	if config.FWDStrategy == "" {
+		config.FWDStrategy = "failover"
+	}
+
+	if *fwdTimeout != 0 {
+		config.FWDTimeout = *fwdTimeout
+	}
+
+	if *fwdRetries != 0 {
+		config.FWDRetries = *fwdRetries
+	}
+
+	if *upstreamProbeInterval == 0 && config.UpstreamProbeInterval == 0 {
+		config.UpstreamProbeInterval = 30
+	} else if *upstreamProbeInterval != 0 {
+		config.UpstreamProbeInterval = *upstreamProbeInterval
+	}
+
+	if *upstreamFailThreshold == 0 && config.UpstreamFailThreshold == 0 {
+		config.UpstreamFailThreshold = 3
+	} else if *upstreamFailThreshold != 0 {
+		config.UpstreamFailThreshold = *upstreamFailThreshold
+	}
+
+	if *upstreamCooldown == 0 && config.UpstreamCooldown == 0 {
+		config.UpstreamCooldown = 30
+	} else if *upstreamCooldown != 0 {
+		config.UpstreamCooldown = *upstreamCooldown
+	}
+
+	// fwdAddr may hold a comma separated list of upstreams. These are
+	// asked before any upstream from the upstreams list
+	var upstreams []Upstream
+	for _, addr := range strings.Split(config.FWDAddr, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		upstreams = append(upstreams, Upstream{
+			Addr:       addr,
+			Proto:      config.FWDProto,
+			TLS:        config.FWDTLS,
+			CaCert:     config.FWDCaCert,
+			ClientCert: config.FWDClientCert,
+			ClientKey:  config.FWDClientKey,
+			Timeout:    config.FWDTimeout,
+			Retries:    config.FWDRetries,
+		})
+	}
+	config.Upstreams = append(upstreams, config.Upstreams...)
+
+	err = checkUpstreams(config.Upstreams, config.FWDProto)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range config.ForwardRules {
+		if len(r.Domains) == 0 || len(r.Upstreams) == 0 {
+			return nil, fmt.Errorf(errForwardRule)
+		}
+
+		err = checkUpstreams(r.Upstreams, config.FWDProto)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if *fwdUDPBufferSize != 0 {
-		config.FWDUDPBufferSize = *fwdUDPBufferSize
+		err = checkUDPBufferSize("fwd udp buffer size", *fwdUDPBufferSize)
+		if err != nil {
+			return nil, err
+		}
+		config.FWDUDPBufferSize = uint16(*fwdUDPBufferSize)
+	}
+
+	if config.FWDUDPBufferSize == 0 {
+		config.FWDUDPBufferSize = 4096
+	}
+
+	err = checkUDPBufferSize("fwd udp buffer size", uint(config.FWDUDPBufferSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if *listenUDPBufferSize != 0 {
+		err = checkUDPBufferSize("listen udp buffer size", *listenUDPBufferSize)
+		if err != nil {
+			return nil, err
+		}
+		config.ListenUDPBufferSize = uint16(*listenUDPBufferSize)
+	}
+
+	if config.ListenUDPBufferSize == 0 {
+		config.ListenUDPBufferSize = 1232
+	}
+
+	err = checkUDPBufferSize("listen udp buffer size", uint(config.ListenUDPBufferSize))
+	if err != nil {
+		return nil, err
 	}
 
 	if *listenAddr != "" {
@@ -137,25 +364,203 @@ func GetNetTrustEnv() (*NetTrust, error) {
 		config.ListenCertKey = *listenCertKey
 	}
 
-	if config.ListenTLS {
-		if config.ListenCert == "" {
-			return nil, fmt.Errorf(errListenTLSNoFile, "certificate")
+	if *listenDoHAddr != "" {
+		config.ListenDoHAddr = *listenDoHAddr
+	}
+
+	if *listenClientCaCert != "" {
+		config.ListenClientCaCert = *listenClientCaCert
+	}
+
+	if *listenClientAuth != "" {
+		config.ListenClientAuth = *listenClientAuth
+	}
+
+	// listenAddr and listenDoHAddr are converted to listeners. The udp listener
+	// is always started, the tcp listener serves DoT if listenTLS is enabled
+	var listeners []Listener
+	if config.ListenAddr != "" {
+		listeners = append(listeners, Listener{Addr: config.ListenAddr, Proto: "udp"})
+		if config.ListenTLS {
+			listeners = append(listeners, Listener{Addr: config.ListenAddr, Proto: "dot"})
+		} else {
+			listeners = append(listeners, Listener{Addr: config.ListenAddr, Proto: "tcp"})
+		}
+	}
+
+	if config.ListenDoHAddr != "" {
+		listeners = append(listeners, Listener{Addr: config.ListenDoHAddr, Proto: "doh"})
+	}
+	config.Listeners = append(listeners, config.Listeners...)
+
+	for i, l := range config.Listeners {
+		switch l.Proto {
+		case "udp", "tcp":
+			continue
+		case "dot", "doh":
+		default:
+			return nil, fmt.Errorf(errListenProto, l.Proto, l.Addr)
+		}
+
+		if l.Cert == "" {
+			config.Listeners[i].Cert = config.ListenCert
+		}
+		if l.CertKey == "" {
+			config.Listeners[i].CertKey = config.ListenCertKey
+		}
+
+		if config.Listeners[i].Cert == "" {
+			return nil, fmt.Errorf(errListenTLSNoFile, l.Addr, "certificate")
 		}
-		if config.ListenCertKey == "" {
-			return nil, fmt.Errorf(errListenTLSNoFile, "private key")
+		if config.Listeners[i].CertKey == "" {
+			return nil, fmt.Errorf(errListenTLSNoFile, l.Addr, "private key")
 		}
-		err = fileExists(config.ListenCert)
+		err = fileExists(config.Listeners[i].Cert)
 		if err != nil {
 			return nil, err
 		}
-		err = fileExists(config.ListenCertKey)
+		err = fileExists(config.Listeners[i].CertKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if l.ClientCaCert == "" {
+			config.Listeners[i].ClientCaCert = config.ListenClientCaCert
+		}
+		if l.ClientAuth == "" {
+			config.Listeners[i].ClientAuth = config.ListenClientAuth
+		}
+
+		if config.Listeners[i].ClientCaCert != "" {
+			err = fileExists(config.Listeners[i].ClientCaCert)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, u := range config.AllUpstreams() {
+		for _, l := range config.Listeners {
+			if l.Addr == u.Addr {
+				return nil, fmt.Errorf(errSameAddr)
+			}
+		}
+	}
+
+	if *domainWhitelistMode != "" {
+		config.DomainWhitelistMode = *domainWhitelistMode
+	}
+
+	if config.DomainWhitelistMode == "" {
+		config.DomainWhitelistMode = "off"
+	}
+
+	if config.DomainWhitelistMode != "off" && len(config.Whitelist.Domains) == 0 {
+		return nil, fmt.Errorf(errDomainWhitelistEmpty, config.DomainWhitelistMode)
+	}
+
+	if *filterAAAA != "" {
+		config.FilterAAAA = *filterAAAA
+	}
+
+	if config.FilterAAAA == "" {
+		config.FilterAAAA = "off"
+	}
+
+	if *blocklistRefresh == 0 && config.BlocklistRefresh == 0 {
+		config.BlocklistRefresh = 86400
+	} else if *blocklistRefresh != 0 {
+		config.BlocklistRefresh = *blocklistRefresh
+	}
+
+	if *blockedResponse != "" {
+		config.BlockedResponse = *blockedResponse
+	}
+
+	if config.BlockedResponse == "" {
+		config.BlockedResponse = "nxdomain"
+	}
+
+	if *blockedSinkhole != "" {
+		config.BlockedSinkhole = splitList(*blockedSinkhole)
+	}
+
+	if *clientsAllow != "" {
+		config.ClientsAllow = splitList(*clientsAllow)
+	}
+
+	if *clientsDeny != "" {
+		config.ClientsDeny = splitList(*clientsDeny)
+	}
+
+	if *clientsDenyResponse != "" {
+		config.ClientsDenyResponse = *clientsDenyResponse
+	}
+
+	if config.ClientsDenyResponse == "" {
+		config.ClientsDenyResponse = "refused"
+	}
+
+	if *clientsAuthorize != "" {
+		config.ClientsAuthorize = splitList(*clientsAuthorize)
+	}
+
+	if *rateLimitQPS != 0 {
+		config.RateLimitQPS = *rateLimitQPS
+	}
+
+	if *rateLimitBurst != 0 {
+		config.RateLimitBurst = *rateLimitBurst
+	}
+
+	if *strikeLimit != 0 {
+		config.StrikeLimit = *strikeLimit
+	}
+
+	if *strikeWindow == 0 && config.StrikeWindow == 0 {
+		config.StrikeWindow = 60
+	} else if *strikeWindow != 0 {
+		config.StrikeWindow = *strikeWindow
+	}
+
+	if *banTime == 0 && config.BanTime == 0 {
+		config.BanTime = 300
+	} else if *banTime != 0 {
+		config.BanTime = *banTime
+	}
+
+	if *banFirewall {
+		config.BanFirewall = *banFirewall
+	}
+
+	if *rrlResponses != 0 {
+		config.RRLResponses = *rrlResponses
+	}
+
+	if *rrlSlip == 0 && config.RRLSlip == 0 {
+		config.RRLSlip = 2
+	} else if *rrlSlip != 0 {
+		config.RRLSlip = *rrlSlip
+	}
+
+	for _, l := range config.Blacklist.Lists {
+		if l.Source == "" {
+			return nil, fmt.Errorf(errBlocklistSource)
+		}
+	}
+
+	for _, z := range config.Zones {
+		if z.File == "" {
+			continue
+		}
+		err = fileExists(z.File)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if config.ListenAddr == config.FWDAddr {
-		return nil, fmt.Errorf(errSameAddr)
+	if *zonesAuthorize {
+		config.ZonesAuthorize = *zonesAuthorize
 	}
 
 	if *firewallBackend == "" && config.FirewallBackend == "" {
@@ -174,12 +579,58 @@ func GetNetTrustEnv() (*NetTrust, error) {
 		config.FirewallDropInput = *firewallDropInput
 	}
 
+	if *enableIPv6 {
+		config.IPv6 = *enableIPv6
+	}
+
 	if *authorizedTTL == 0 && config.AuthorizedTTL == 0 {
 		config.AuthorizedTTL = -1
 	} else if *authorizedTTL != 0 {
 		config.AuthorizedTTL = *authorizedTTL
 	}
 
+	if *authorizedTTLMode != "" {
+		config.AuthorizedTTLMode = *authorizedTTLMode
+	}
+
+	if config.AuthorizedTTLMode == "" {
+		config.AuthorizedTTLMode = "fixed"
+	}
+
+	if config.AuthorizedTTLMode != "fixed" && config.AuthorizedTTLMode != "answer" {
+		return nil, fmt.Errorf(errAuthorizedTTLMode, config.AuthorizedTTLMode)
+	}
+
+	if *authorizedTTLMin == 0 && config.AuthorizedTTLMin == 0 {
+		config.AuthorizedTTLMin = 60
+	} else if *authorizedTTLMin != 0 {
+		config.AuthorizedTTLMin = *authorizedTTLMin
+	}
+
+	if config.AuthorizedTTLMin < 0 {
+		config.AuthorizedTTLMin = 0
+	}
+
+	if *authorizedTTLMax == 0 && config.AuthorizedTTLMax == 0 {
+		config.AuthorizedTTLMax = 86400
+	} else if *authorizedTTLMax != 0 {
+		config.AuthorizedTTLMax = *authorizedTTLMax
+	}
+
+	if *authorizedTTLGrace == 0 && config.AuthorizedTTLGrace == 0 {
+		config.AuthorizedTTLGrace = 60
+	} else if *authorizedTTLGrace != 0 {
+		config.AuthorizedTTLGrace = *authorizedTTLGrace
+	}
+
+	if config.AuthorizedTTLGrace < 0 {
+		config.AuthorizedTTLGrace = 0
+	}
+
+	if config.AuthorizedTTLMax < 1 || config.AuthorizedTTLMax < config.AuthorizedTTLMin {
+		return nil, fmt.Errorf(errAuthorizedTTLMax, config.AuthorizedTTLMax, config.AuthorizedTTLMin)
+	}
+
 	if *ttlCheckTicker == 0 && config.TTLCheckTicker == 0 {
 		config.TTLCheckTicker = 30
 	} else if *ttlCheckTicker != 0 {
@@ -192,8 +643,51 @@ func GetNetTrustEnv() (*NetTrust, error) {
 		config.DNSTTLCache = *dnsTTLCache
 	}
 
+	if *dnsCacheMaxEntries == 0 && config.DNSCacheMaxEntries == 0 {
+		config.DNSCacheMaxEntries = 10000
+	} else if *dnsCacheMaxEntries != 0 {
+		config.DNSCacheMaxEntries = *dnsCacheMaxEntries
+	}
+
+	if *dnsCacheMaxBytes == 0 && config.DNSCacheMaxBytes == 0 {
+		config.DNSCacheMaxBytes = 16 << 20
+	} else if *dnsCacheMaxBytes != 0 {
+		config.DNSCacheMaxBytes = *dnsCacheMaxBytes
+	}
+
+	if *dnsCacheServeStale != 0 {
+		config.DNSCacheServeStale = *dnsCacheServeStale
+	}
+
+	if *dnsCachePrefetch != 0 {
+		config.DNSCachePrefetch = *dnsCachePrefetch
+	}
+
+	if *dnsCacheFile != "" {
+		config.DNSCacheFile = *dnsCacheFile
+	}
+
+	if config.DNSCacheFile != "" {
+		if f, err := os.Stat(config.DNSCacheFile); err == nil && f.IsDir() {
+			return nil, fmt.Errorf("[%s] is a directory", config.DNSCacheFile)
+		}
+
+		if _, err := os.Stat(filepath.Dir(config.DNSCacheFile)); err != nil {
+			return nil, fmt.Errorf(errCacheFileDir, config.DNSCacheFile, err)
+		}
+	}
+
+	if *dnsCacheSaveInterval == 0 && config.DNSCacheSaveInterval == 0 {
+		config.DNSCacheSaveInterval = 300
+	} else if *dnsCacheSaveInterval != 0 {
+		config.DNSCacheSaveInterval = *dnsCacheSaveInterval
+	}
+
 	if *whitelistLoopback || config.WhitelistLoEnabled {
 		config.WhitelistLo = []string{"127.0.0.0/8"}
+		if config.IPv6 {
+			config.WhitelistLo = append(config.WhitelistLo, "::1/128")
+		}
 	}
 
 	if *whitelistPrivate || config.WhitelistPrivateEnabled {
@@ -203,11 +697,99 @@ func GetNetTrustEnv() (*NetTrust, error) {
 			"192.168.0.0/16",
 			"100.64.0.0/10",
 		}
+		if config.IPv6 {
+			// Unique local and link local addresses
+			config.WhitelistPrivate = append(config.WhitelistPrivate, "fc00::/7", "fe80::/10")
+		}
+	}
+
+	for _, n := range append(append([]string{}, config.Whitelist.Networks...), config.Blacklist.Networks...) {
+		err = CheckIPNetwork(n, config.IPv6)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, h := range append(append([]string{}, config.Whitelist.Hosts...), config.Blacklist.Hosts...) {
+		err = CheckIPAddresses(h, config.IPv6)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return config, nil
 }
 
+// AllUpstreams returns the default upstreams along with the upstreams of all forward rules
+func (c *NetTrust) AllUpstreams() []Upstream {
+	upstreams := append([]Upstream{}, c.Upstreams...)
+	for _, r := range c.ForwardRules {
+		upstreams = append(upstreams, r.Upstreams...)
+	}
+
+	return upstreams
+}
+
+// checkUpstreams sets defaultProto on upstreams with no proto and checks that CA and
+// client certificate files exist
+func checkUpstreams(upstreams []Upstream, defaultProto string) error {
+	for i := range upstreams {
+		if upstreams[i].Proto == "" {
+			upstreams[i].Proto = defaultProto
+		}
+
+		hasTLS := upstreams[i].TLS || upstreams[i].Proto == "https"
+		if hasTLS && upstreams[i].CaCert != "" {
+			err := fileExists(upstreams[i].CaCert)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !hasTLS {
+			continue
+		}
+
+		if (upstreams[i].ClientCert == "") != (upstreams[i].ClientKey == "") {
+			return fmt.Errorf(errUpstreamClientCert, upstreams[i].Addr)
+		}
+
+		for _, f := range []string{upstreams[i].ClientCert, upstreams[i].ClientKey} {
+			if f == "" {
+				continue
+			}
+			err := fileExists(f)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkUDPBufferSize checks that size is a valid EDNS0 udp buffer size. Sizes below 512
+// are not allowed by RFC 6891 and sizes above 65535 do not fit in the OPT record
+func checkUDPBufferSize(name string, size uint) error {
+	if size < 512 || size > 65535 {
+		return fmt.Errorf(errUDPBufferSize, name, size)
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated flag value and drops empty entries
+func splitList(list string) []string {
+	entries := []string{}
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
 func fileExists(file string) error {
 	f, err := os.Stat(file)
 	if os.IsNotExist(err) {
@@ -2,15 +2,30 @@ package core
 
 var (
 	errSameAddr             string = "listen address can not be the same as forward address"
-	errListenTLSNoFile      string = "listen-tls is enabled but no %s was provided"
+	errListenTLSNoFile      string = "tls listener [%s] is enabled but no %s was provided"
+	errListenProto          string = "listen proto [%s] for [%s] is not supported. Supported: udp, tcp, dot, doh"
 	errInvalidSocketAddress string = "address [%s] is not valid. Expected ip:port"
 	errInvalidPort          string = "invalid port [%d] number"
 	errNotValidIPv4Addr     string = "not a valid ipv4 address [%s]"
 	errNotValidIPv4Network  string = "not a valid ipv4 network [%s]"
+	errNotValidIPv6Addr     string = "not a valid ipv6 address [%s]"
+	errNotValidIPv6Network  string = "not a valid ipv6 network [%s]"
+	errIPv6Disabled         string = "[%s] is an ipv6 address but ipv6 is not enabled"
+	errForwardRule          string = "forward rules require at least one domain and one upstream"
+	errBlocklistSource      string = "blacklist.lists entries require a source"
+	errUpstreamClientCert   string = "upstream [%s] requires both a client certificate and a client key"
+	errCacheFileDir         string = "dns cache file [%s] can not be created: %s"
+	errDomainWhitelistEmpty string = "domain whitelist mode is [%s] but whitelist.domains is empty, all queries would be denied"
+	errAuthorizedTTLMode    string = "authorized ttl mode [%s] is not supported. Supported: fixed, answer"
+	errAuthorizedTTLMax     string = "authorized ttl max [%d] must be positive and not lower than authorized ttl min [%d]"
+	errUDPBufferSize        string = "%s [%d] is not valid. Expected a value between 512 and 65535"
 
 	// WarnOnExitFlushAuthorized will be printed when authorized hosts are preserved on NetTrust exit
 	WarnOnExitFlushAuthorized string = "on exit NetTrust will not flush the authorized hosts list"
 
 	// WarnOnExitFlush will be printed when on exit flush table is enabled
 	WarnOnExitFlush string = "on exit flush table is enabled. Please set this to false if you wish to deny traffic to all if NetTrust is not running"
+
+	// WarnFilterAAAAIPv6 will be printed when AAAA filtering is configured while IPv6 is authorized
+	WarnFilterAAAAIPv6 string = "ipv6 is enabled, AAAA answers are authorized and will not be filtered"
 )
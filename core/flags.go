@@ -6,20 +6,51 @@ var (
 	doNotFlushTable                       *bool
 	doNotFlushAuthorizedHosts, fwdTLS     *bool
 	fwdAddr, fwdProto, fwdTLSCert         *string
-	fwdUDPBufferSize                      *uint16
+	fwdClientCert, fwdClientKey           *string
+	fwdStrategy                           *string
+	fwdTimeout, fwdRetries                *int
+	fwdUDPBufferSize, listenUDPBufferSize *uint
 	listenAddr, listenCert, listenCertKey *string
 	listenTLS                             *bool
+	listenDoHAddr                         *string
+	listenClientCaCert, listenClientAuth  *string
 
 	firewallBackend, firewallType *string
 	firewallDropInput             *bool
+	enableIPv6                    *bool
+	filterAAAA                    *string
 
 	whitelistLoopback, whitelistPrivate *bool
 
-	authorizedTTL, ttlCheckTicker *int
+	authorizedTTL, ttlCheckTicker      *int
+	authorizedTTLMode                  *string
+	authorizedTTLMin, authorizedTTLMax *int
+	authorizedTTLGrace                 *int
 
 	fileCFG *string
 
-	dnsTTLCache *int
+	dnsTTLCache                          *int
+	dnsCacheMaxEntries, dnsCacheMaxBytes *int
+	dnsCacheServeStale, dnsCachePrefetch *int
+	dnsCacheFile                         *string
+	dnsCacheSaveInterval                 *int
+
+	domainWhitelistMode *string
+	blocklistRefresh    *int
+
+	blockedResponse, blockedSinkhole *string
+
+	clientsAllow, clientsDeny             *string
+	clientsDenyResponse, clientsAuthorize *string
+
+	rateLimitQPS, rateLimitBurst       *int
+	strikeLimit, strikeWindow, banTime *int
+	banFirewall                        *bool
+	rrlResponses, rrlSlip              *int
+
+	zonesAuthorize *bool
+
+	upstreamProbeInterval, upstreamFailThreshold, upstreamCooldown *int
 )
 
 func init() {
@@ -34,8 +65,38 @@ func init() {
 		"Do not clean up the authorized hosts list on exit. Use this together with do-not-flush-table to keep the NetTrust table as is on exit",
 	)
 
-	fwdAddr = flag.String("fwd-addr", "", "NetTrust forward dns address")
-	fwdProto = flag.String("fwd-proto", "", "NetTrust dns forward protocol")
+	fwdAddr = flag.String("fwd-addr", "", "NetTrust forward dns address. Use a comma separated list to set more than one upstreams")
+	fwdStrategy = flag.String(
+		"fwd-strategy",
+		"",
+		"How NetTrust picks upstreams when more than one is set. Supported: failover (default), round-robin, lowest-latency, parallel",
+	)
+	fwdTimeout = flag.Int(
+		"fwd-timeout",
+		0,
+		"Timeout in milliseconds for queries to fwd-addr upstreams, default 2000 (5000 for DoH)",
+	)
+	fwdRetries = flag.Int(
+		"fwd-retries",
+		0,
+		"Number of times a failed query is retried on the same fwd-addr upstream before the next upstream is asked",
+	)
+	upstreamProbeInterval = flag.Int(
+		"upstream-probe-interval",
+		0,
+		"Number of seconds between upstream health probes, default 30 (-1 to disable)",
+	)
+	upstreamFailThreshold = flag.Int(
+		"upstream-fail-threshold",
+		0,
+		"Number of consecutive failures after which an upstream is skipped until its cooldown passes, default 3",
+	)
+	upstreamCooldown = flag.Int(
+		"upstream-cooldown",
+		0,
+		"Number of seconds a failing upstream is skipped before it is tried again, default 30",
+	)
+	fwdProto = flag.String("fwd-proto", "", "NetTrust dns forward protocol [udp/tcp/https]. Use https for DoH")
 	fwdTLS = flag.Bool(
 		"fwd-tls",
 		false,
@@ -47,13 +108,43 @@ func init() {
 		"path to certificate that will be used to validate forward dns hostname. If you do not set this, the the host root CAs will be used",
 	)
 
-	ubs := uint16(*flag.Uint("fwd-udp-buffer-size", 0, "forward client udp buffer size, default 4096"))
-	fwdUDPBufferSize = &ubs
+	fwdClientCert = flag.String(
+		"fwd-tls-client-cert",
+		"",
+		"path to a client certificate that will be presented to DoT/DoH forward dns servers that require client authentication",
+	)
+	fwdClientKey = flag.String(
+		"fwd-tls-client-key",
+		"",
+		"path to the private key of fwd-tls-client-cert",
+	)
+
+	fwdUDPBufferSize = flag.Uint("fwd-udp-buffer-size", 0, "EDNS0 udp buffer size advertised to upstreams, default 4096. Truncated answers are asked again over tcp")
+	listenUDPBufferSize = flag.Uint(
+		"listen-udp-buffer-size",
+		0,
+		"Maximum EDNS0 udp buffer size for answers to clients, default 1232. Larger answers are truncated and clients retry over tcp",
+	)
 
 	listenAddr = flag.String("listen-addr", "", "NetTrust listen dns address")
 	listenTLS = flag.Bool("listen-tls", false, "Enable tls listener, tls listener works only with the TCP DNS Service, UDP will continue to serve in plaintext mode")
+	listenDoHAddr = flag.String(
+		"listen-doh-addr",
+		"",
+		"NetTrust DoH listen address. If set, a DNS-over-HTTPS service is started on /dns-query using listen-cert and listen-cert-key",
+	)
 	listenCert = flag.String("listen-cert", "", "path to certificate that will be used by the TCP DNS Service to serve DoT")
 	listenCertKey = flag.String("listen-cert-key", "", "path to the private key that will be used by the TCP DNS Service to serve DoT")
+	listenClientCaCert = flag.String(
+		"listen-client-ca-cert",
+		"",
+		"path to a CA certificate for verifying DoT/DoH client certificates. If set, clients must present a certificate signed by this CA",
+	)
+	listenClientAuth = flag.String(
+		"listen-client-auth",
+		"",
+		"DoT/DoH client certificate mode. Supported: none (default), request, require, verify-if-given, require-and-verify (default when listen-client-ca-cert is set)",
+	)
 
 	firewallBackend = flag.String(
 		"firewall-backend",
@@ -71,15 +162,27 @@ func init() {
 		"If enabled, NetTrust will drop input. Adds [ct state established,related accept] & ['lo' accept]. Should be enabled only when NetTrust runs in host",
 	)
 
+	enableIPv6 = flag.Bool(
+		"ipv6",
+		false,
+		"Filter IPv6 traffic in an ip6 table and authorize AAAA answers. If disabled, NetTrust does not filter IPv6 traffic",
+	)
+
+	filterAAAA = flag.String(
+		"filter-aaaa",
+		"",
+		"Filter AAAA records while ipv6 is not enabled. Supported: off (default), strip (remove AAAA records from answers), nodata (answer AAAA queries with NODATA)",
+	)
+
 	whitelistLoopback = flag.Bool(
 		"whitelist-loopback",
 		true,
-		"Loopback network space 127.0.0.0/8 will be whitelisted (default true)",
+		"Loopback network space 127.0.0.0/8 (and ::1/128 with ipv6) will be whitelisted (default true)",
 	)
 	whitelistPrivate = flag.Bool(
 		"whitelist-private",
 		true,
-		"If 10.0.0.0/8, 172.16.0.0/16, 192.168.0.0/16, 100.64.0.0/10 will be whitelisted (default true)",
+		"If 10.0.0.0/8, 172.16.0.0/16, 192.168.0.0/16, 100.64.0.0/10 (and fc00::/7, fe80::/10 with ipv6) will be whitelisted (default true)",
 	)
 
 	authorizedTTL = flag.Int(
@@ -87,6 +190,26 @@ func init() {
 		0,
 		"Number of seconds a authorized host will be active before NetTrust expires it and expect a DNS query again (-1 do not expire)",
 	)
+	authorizedTTLMode = flag.String(
+		"authorized-ttl-mode",
+		"",
+		"How long authorized hosts stay authorized. Supported: fixed (default, authorized-ttl), answer (TTL of the answer record, bounded by authorized-ttl-min and authorized-ttl-max, plus authorized-ttl-grace)",
+	)
+	authorizedTTLMin = flag.Int(
+		"authorized-ttl-min",
+		0,
+		"Minimum number of seconds a host is authorized for in answer ttl mode, default 60 (-1 for none)",
+	)
+	authorizedTTLMax = flag.Int(
+		"authorized-ttl-max",
+		0,
+		"Maximum number of seconds a host is authorized for in answer ttl mode, default 86400",
+	)
+	authorizedTTLGrace = flag.Int(
+		"authorized-ttl-grace",
+		0,
+		"Number of seconds added to the answer TTL in answer ttl mode, default 60 (-1 for none)",
+	)
 	ttlCheckTicker = flag.Int(
 		"ttl-check-ticker",
 		0,
@@ -95,6 +218,127 @@ func init() {
 
 	fileCFG = flag.String("config", "", "Path to config.json")
 
-	dnsTTLCache = flag.Int("dns-ttl-cache", 0, "Number of seconds dns queries stay in cache (-1 to disable caching)")
+	domainWhitelistMode = flag.String(
+		"domain-whitelist-mode",
+		"",
+		"Forward only queries for domains in whitelist.domains. Supported: off (default), nxdomain, refused. The mode sets the answer for domains that are not whitelisted",
+	)
+
+	blocklistRefresh = flag.Int(
+		"blocklist-refresh",
+		0,
+		"Number of seconds between blacklist.lists refreshes, default 86400 (-1 to disable). Local list files are also reloaded when they change",
+	)
+
+	blockedResponse = flag.String(
+		"blocked-response",
+		"",
+		"Answer for blacklisted domains. Supported: nxdomain (default), refused, nodata, null (0.0.0.0 and ::), sinkhole, servfail",
+	)
+	blockedSinkhole = flag.String(
+		"blocked-sinkhole",
+		"",
+		"Comma separated list of IPs that blacklisted A/AAAA queries are answered with when blocked-response is sinkhole",
+	)
+
+	clientsAllow = flag.String(
+		"clients-allow",
+		"",
+		"Comma separated list of client networks (CIDR) or IPs that can query NetTrust. If empty, all clients that are not denied can query NetTrust",
+	)
+	clientsDeny = flag.String(
+		"clients-deny",
+		"",
+		"Comma separated list of client networks (CIDR) or IPs that can not query NetTrust. Deny takes precedence over allow",
+	)
+	clientsDenyResponse = flag.String(
+		"clients-deny-response",
+		"",
+		"Answer for queries from denied clients. Supported: refused (default), nxdomain, servfail, drop",
+	)
+	clientsAuthorize = flag.String(
+		"clients-authorize",
+		"",
+		"Comma separated list of client networks (CIDR) or IPs whose queries are authorized in the firewall. If empty, queries from all allowed clients are authorized",
+	)
+
+	rateLimitQPS = flag.Int(
+		"rate-limit-qps",
+		0,
+		"Number of queries per second a client can send before it is refused. Disabled by default",
+	)
+	rateLimitBurst = flag.Int(
+		"rate-limit-burst",
+		0,
+		"Number of queries a client can send in a burst over rate-limit-qps, default 2*rate-limit-qps",
+	)
+	strikeLimit = flag.Int(
+		"strike-limit",
+		0,
+		"Number of blocked, NXDOMAIN or malformed queries within strike-window after which a client is banned. Disabled by default",
+	)
+	strikeWindow = flag.Int(
+		"strike-window",
+		0,
+		"Number of seconds strikes are counted for, default 60",
+	)
+	banTime = flag.Int(
+		"ban-time",
+		0,
+		"Number of seconds a client that reached strike-limit is banned for, default 300",
+	)
+	banFirewall = flag.Bool(
+		"ban-firewall",
+		false,
+		"Also drop all inbound traffic from banned clients in the firewall for ban-time. IPv6 clients are dropped only with ipv6",
+	)
+	rrlResponses = flag.Int(
+		"rrl-responses",
+		0,
+		"Number of identical udp responses per second to a client network (/24, /56) before responses are rate limited. Disabled by default",
+	)
+	rrlSlip = flag.Int(
+		"rrl-slip",
+		0,
+		"Every rrl-slip'th rate limited response is sent truncated so clients retry over tcp, the rest are dropped, default 2 (-1 to drop all)",
+	)
+
+	zonesAuthorize = flag.Bool(
+		"zones-authorize",
+		false,
+		"Authorize in the firewall the hosts that are answered from local zones",
+	)
+
+	dnsTTLCache = flag.Int("dns-ttl-cache", 0, "Maximum number of seconds dns answers stay in cache. Answers expire earlier when their TTL is lower (-1 to disable caching)")
+	dnsCacheMaxEntries = flag.Int(
+		"dns-cache-max-entries",
+		0,
+		"Maximum number of answers in the dns cache, default 10000 (-1 for no limit). Least recently used answers are evicted first",
+	)
+	dnsCacheMaxBytes = flag.Int(
+		"dns-cache-max-bytes",
+		0,
+		"Maximum estimated memory in bytes used by the dns cache, default 16777216 (-1 for no limit)",
+	)
+	dnsCacheServeStale = flag.Int(
+		"dns-cache-serve-stale",
+		0,
+		"Number of seconds expired answers are kept in cache and served when upstream fails (RFC 8767). Disabled by default",
+	)
+	dnsCachePrefetch = flag.Int(
+		"dns-cache-prefetch",
+		0,
+		"Refresh cached answers that had at least this number of hits shortly before they expire. Disabled by default",
+	)
+	dnsCacheFile = flag.String(
+		"dns-cache-file",
+		"",
+		"Path to a file for saving the dns cache on exit and loading it on start. Disabled by default",
+	)
+	dnsCacheSaveInterval = flag.Int(
+		"dns-cache-save-interval",
+		0,
+		"Number of seconds between dns cache saves to dns-cache-file, default 300 (-1 to save only on exit)",
+	)
 
 }
@@ -26,6 +26,28 @@ func NewOSSignal() OSSignalHandler {
 	return osSig
 }
 
+// NewHostsSignal for creating a new SIGUSR1 signal. NetTrust logs the records of the
+// authorized hosts when it receives SIGUSR1
+func NewHostsSignal() OSSignalHandler {
+	osSig := OSSignalHandler{}
+
+	osSig.Signal = make(chan os.Signal, 1)
+	signal.Notify(osSig.Signal, syscall.SIGUSR1)
+
+	return osSig
+}
+
+// NewUpstreamsSignal for creating a new SIGUSR2 signal. NetTrust logs the health of the
+// upstreams when it receives SIGUSR2
+func NewUpstreamsSignal() OSSignalHandler {
+	osSig := OSSignalHandler{}
+
+	osSig.Signal = make(chan os.Signal, 1)
+	signal.Notify(osSig.Signal, syscall.SIGUSR2)
+
+	return osSig
+}
+
 // Wait for waiting for an OS signal
 func (s *OSSignalHandler) Wait() {
 	<-s.Signal
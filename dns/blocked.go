@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// BlockedNXDomain answers blocked queries with NXDOMAIN
+	BlockedNXDomain = "nxdomain"
+	// BlockedRefused answers blocked queries with REFUSED
+	BlockedRefused = "refused"
+	// BlockedNoData answers blocked queries with NOERROR and an empty answer section
+	BlockedNoData = "nodata"
+	// BlockedNullIP answers blocked A queries with 0.0.0.0 and AAAA queries with ::
+	BlockedNullIP = "null"
+	// BlockedSinkhole answers blocked A and AAAA queries with the configured sinkhole IPs
+	BlockedSinkhole = "sinkhole"
+	// BlockedServFail answers blocked queries with SERVFAIL
+	BlockedServFail = "servfail"
+
+	// blockedTTL is the TTL of synthesized answers for blocked queries
+	blockedTTL = 60
+
+	edeTextBlacklisted    = "blocked by NetTrust blacklist"
+	edeTextNotWhitelisted = "not in NetTrust domain whitelist"
+)
+
+// blockedResponse builds the answers to blacklisted queries. A and AAAA queries are
+// answered with the null or sinkhole IPs in null and sinkhole modes, other query types
+// and families without a sinkhole IP are answered with NODATA
+type blockedResponse struct {
+	mode       string
+	ipv4, ipv6 []net.IP
+}
+
+func newBlockedResponse(mode string, sinkhole []string) (*blockedResponse, error) {
+	b := &blockedResponse{mode: mode}
+
+	switch mode {
+	case "":
+		b.mode = BlockedNXDomain
+	case BlockedNXDomain, BlockedRefused, BlockedNoData, BlockedServFail:
+	case BlockedNullIP:
+		b.ipv4 = []net.IP{net.IPv4zero}
+		b.ipv6 = []net.IP{net.IPv6zero}
+	case BlockedSinkhole:
+		for _, s := range sinkhole {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				return nil, fmt.Errorf(errBlockedSinkhole, s)
+			}
+			if ip.To4() != nil {
+				b.ipv4 = append(b.ipv4, ip.To4())
+				continue
+			}
+			b.ipv6 = append(b.ipv6, ip)
+		}
+		if len(b.ipv4) == 0 && len(b.ipv6) == 0 {
+			return nil, fmt.Errorf(errBlockedNoSinkhole)
+		}
+	default:
+		return nil, fmt.Errorf(errBlockedResponse, mode)
+	}
+
+	return b, nil
+}
+
+// msg returns the answer to a blocked query along with an RFC 8914 extended dns error
+func (b *blockedResponse) msg(req *dns.Msg, ede uint16, text string) *dns.Msg {
+	m := new(dns.Msg)
+
+	switch b.mode {
+	case BlockedNXDomain:
+		m.SetRcode(req, dns.RcodeNameError)
+	case BlockedRefused:
+		m.SetRcode(req, dns.RcodeRefused)
+	case BlockedServFail:
+		m.SetRcode(req, dns.RcodeServerFailure)
+	default:
+		m.SetReply(req)
+		m.Answer = b.answer(req.Question[0])
+	}
+
+	m.RecursionAvailable = true
+	setEDE(m, ede, text)
+
+	return m
+}
+
+// answer returns the null or sinkhole records for q, or nothing for NODATA
+func (b *blockedResponse) answer(q dns.Question) []dns.RR {
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Rrtype: q.Qtype, Ttl: blockedTTL}
+
+	rrs := []dns.RR{}
+	switch q.Qtype {
+	case dns.TypeA:
+		for _, ip := range b.ipv4 {
+			rrs = append(rrs, &dns.A{Hdr: hdr, A: ip})
+		}
+	case dns.TypeAAAA:
+		for _, ip := range b.ipv6 {
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	return rrs
+}
+
+// denyBlacklisted answers a query for a blacklisted domain based on the blocked response mode
+func (s *Server) denyBlacklisted(w dns.ResponseWriter, req *dns.Msg) {
+	err := s.writeMsg(w, req, s.blocked.msg(req, dns.ExtendedErrorCodeBlocked, edeTextBlacklisted))
+	if err != nil {
+		s.fwdl.Error(err)
+	}
+}
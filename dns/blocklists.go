@@ -0,0 +1,211 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ulfox/nettrust/dns/domains"
+)
+
+const (
+	blocklistWatchInterval = 10 * time.Second
+	blocklistFetchTimeout  = 30 * time.Second
+	blocklistMaxErrLogs    = 5
+)
+
+// Blocklist describes a domain blocklist. Source is either a local file or an http(s) url.
+// Format can be auto, hosts, domains or adblock
+type Blocklist struct {
+	Source, Format string
+}
+
+// blocklist keeps the last successfully parsed entries of a Blocklist
+type blocklist struct {
+	Blocklist
+	modTime time.Time
+	entries []string
+	loaded  bool
+}
+
+func (b *blocklist) isURL() bool {
+	return strings.HasPrefix(b.Source, "http://") || strings.HasPrefix(b.Source, "https://")
+}
+
+// blocklists holds the inline blacklist domains together with all the configured lists
+type blocklists struct {
+	inline      []string
+	lists       []*blocklist
+	refresh     time.Duration
+	lastRefresh time.Time
+	client      *http.Client
+	logger      *logrus.Entry
+}
+
+func newBlocklists(inline []string, lists []Blocklist, refresh int, logger *logrus.Logger) *blocklists {
+	b := &blocklists{
+		inline:  inline,
+		refresh: time.Duration(refresh) * time.Second,
+		client:  &http.Client{Timeout: blocklistFetchTimeout},
+		logger: logger.WithFields(logrus.Fields{
+			"Component": "DNS Blocklists",
+			"Stage":     "Load",
+		}),
+	}
+
+	for _, l := range lists {
+		b.lists = append(b.lists, &blocklist{Blocklist: l})
+	}
+
+	return b
+}
+
+// load reads the lists that have changed since the last load. URLs are fetched only
+// when force is true. Returns true if any list was (re)loaded
+func (b *blocklists) load(force bool) bool {
+	changed := false
+
+	for _, l := range b.lists {
+		var r io.ReadCloser
+		var err error
+
+		if l.isURL() {
+			if !force && l.loaded {
+				continue
+			}
+			r, err = b.fetch(l.Source)
+		} else {
+			var f os.FileInfo
+			f, err = os.Stat(l.Source)
+			if err == nil {
+				if !force && l.loaded && f.ModTime().Equal(l.modTime) {
+					continue
+				}
+				l.modTime = f.ModTime()
+				r, err = os.Open(l.Source)
+			}
+		}
+
+		if err != nil {
+			b.logger.Errorf(errBlocklistLoad, l.Source, err)
+			continue
+		}
+
+		entries, errs := domains.ParseList(r, l.Format)
+		r.Close()
+
+		for i, e := range errs {
+			if i == blocklistMaxErrLogs {
+				b.logger.Warnf(warnBlocklistMoreErrs, l.Source, len(errs)-i)
+				break
+			}
+			b.logger.Warnf(warnBlocklistParse, l.Source, e)
+		}
+
+		b.logger.Infof(infoBlocklistLoaded, l.Source, len(entries), len(errs))
+
+		l.entries = entries
+		l.loaded = true
+		changed = true
+	}
+
+	if force {
+		b.lastRefresh = time.Now()
+	}
+
+	return changed
+}
+
+func (b *blocklists) fetch(url string) (io.ReadCloser, error) {
+	resp, err := b.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf(errBlocklistStatus, resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// matcher builds a new domain matcher from the inline domains and all loaded lists. Entries
+// from lists that fail to be added are skipped, inline entries have been validated on startup
+func (b *blocklists) matcher() (*domains.Matcher, error) {
+	m, err := domains.NewMatcher(b.inline)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range b.lists {
+		for _, e := range l.entries {
+			if err := m.Add(e); err != nil {
+				b.logger.Warnf(warnBlocklistParse, l.Source, err)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// reloadBlacklist (re)loads blocklists and swaps the domain blacklist used by Server.fwd
+func (s *Server) reloadBlacklist(force bool) error {
+	if !s.blocklists.load(force) && s.domainBlacklist.Load() != nil {
+		return nil
+	}
+
+	m, err := s.blocklists.matcher()
+	if err != nil {
+		return err
+	}
+
+	s.domainBlacklist.Store(m)
+	s.blocklists.logger.Infof(infoBlacklistSize, m.Len())
+
+	return nil
+}
+
+// BlocklistsBackground spawns a goroutine that reloads blocklists when a list file changes
+// or when the refresh interval has passed
+func (s *Server) BlocklistsBackground() *ServiceContext {
+	blocklistsContext := &ServiceContext{}
+
+	var serviceWG sync.WaitGroup
+	blocklistsContext.wg = &serviceWG
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blocklistsContext.cancel = cancel
+
+	serviceWG.Add(1)
+	go func(ctx context.Context, wg *sync.WaitGroup, l *logrus.Entry) {
+		ticker := time.NewTicker(blocklistWatchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				l.WithField("Stage", "Term").Info("Bye!")
+				wg.Done()
+				return
+			case <-ticker.C:
+				if len(s.blocklists.lists) == 0 {
+					break
+				}
+				force := s.blocklists.refresh > 0 &&
+					time.Since(s.blocklists.lastRefresh) > s.blocklists.refresh
+				if err := s.reloadBlacklist(force); err != nil {
+					l.Error(err)
+				}
+			}
+		}
+	}(ctx, &serviceWG, s.blocklists.logger)
+
+	return blocklistsContext
+}
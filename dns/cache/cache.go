@@ -1,6 +1,7 @@
 package queries
 
 import (
+	"container/list"
 	"strings"
 	"sync"
 	"time"
@@ -8,240 +9,389 @@ import (
 	"github.com/miekg/dns"
 )
 
-// Answered for storing dns replies with TTL
+const (
+	// entryOverhead is a rough estimate of the memory used by a cache entry besides
+	// the dns message itself (map slot, list element, key and timestamps)
+	entryOverhead = 160
+	// staleTTL is the TTL of stale answers as recommended by RFC 8767
+	staleTTL = 30
+)
+
+// Key for identifying a cached question by name, type and class
+type Key struct {
+	Name   string
+	Qtype  uint16
+	Qclass uint16
+}
+
+// String returns the key in "name type class" form
+func (k Key) String() string {
+	return strings.Join([]string{k.Name, dns.TypeToString[k.Qtype], dns.ClassToString[k.Qclass]}, " ")
+}
+
+// Answered for storing dns replies along with the time they were stored and
+// the time they expire
 type Answered struct {
 	M *dns.Msg
 	T time.Time
+	E time.Time
 }
 
-// Queries for storing dns answers
+type entry struct {
+	key         Key
+	a           Answered
+	size        int
+	ttl         time.Duration
+	hits        int
+	prefetching bool
+}
+
+// Queries for storing dns answers. The cache is bounded by maxEntries and maxBytes,
+// least recently used answers are evicted first. Expired answers are kept for stale
+// seconds so they can be served when upstream fails (RFC 8767)
 type Queries struct {
 	sync.Mutex
-	ttl      int
-	resolved map[string]Answered
-	nx       map[string]time.Time
+	ttl          int
+	maxEntries   int
+	maxBytes     int
+	stale        time.Duration
+	prefetchHits int
+	bytes        int
+	lru          *list.List
+	resolved     map[Key]*list.Element
+	expiry       map[int64][]Key
+	swept        int64
 }
 
-// NewCache creates a new empty cache
-func NewCache(ttl int) *Queries {
+// NewCache creates a new empty cache. ttl is the maximum number of seconds
+// an answer can stay in cache. maxEntries and maxBytes bound the size of
+// the cache, a value <= 0 means no limit. stale is the number of seconds
+// an expired answer can still be served by GetStale. Answers with at least
+// prefetchHits hits are reported for prefetching by Get during the last 10%
+// of their TTL, 0 disables prefetching
+func NewCache(ttl, maxEntries, maxBytes, stale, prefetchHits int) *Queries {
+	if stale < 0 {
+		stale = 0
+	}
+
 	return &Queries{
-		ttl:      ttl,
-		resolved: make(map[string]Answered),
-		nx:       make(map[string]time.Time),
+		ttl:          ttl,
+		maxEntries:   maxEntries,
+		maxBytes:     maxBytes,
+		stale:        time.Duration(stale) * time.Second,
+		prefetchHits: prefetchHits,
+		lru:          list.New(),
+		resolved:     make(map[Key]*list.Element),
+		expiry:       make(map[int64][]Key),
+		swept:        time.Now().Unix(),
 	}
 }
 
-// NewResolved replaces current cache.resolved map with a new map
-// by copying all the elements. We  this to free up memory, since
-// the allocated memory by cache.resolved is that that the map had at
-// its peak
-func (c *Queries) NewResolved() {
+// GetTTL return cache.ttl value
+func (c *Queries) GetTTL() int {
+	return c.ttl
+}
+
+// Len (blocking) returns the number of cached answers and their estimated size in bytes
+func (c *Queries) Len() (int, int) {
 	c.Lock()
 	defer c.Unlock()
 
-	newMap := make(map[string]Answered)
+	return c.lru.Len(), c.bytes
+}
 
-	for k, v := range c.resolved {
-		newMap[k] = v
-	}
+// Question returns dns.Msg.Question[0].Name from a given dns message
+func (c *Queries) Question(msg *dns.Msg) string {
+	return strings.TrimSuffix(msg.Question[0].Name, ".")
+}
 
-	c.resolved = nil
-	c.resolved = newMap
+// KeyOf returns the cache key of a dns message
+func KeyOf(msg *dns.Msg) Key {
+	q := msg.Question[0]
+	return Key{
+		Name:   strings.ToLower(q.Name),
+		Qtype:  q.Qtype,
+		Qclass: q.Qclass,
+	}
 }
 
-// NewNX replaces current cache.nx map with a new map
-// by copying all the elements. See cache.NewResolved
-// for additional information
-func (c *Queries) NewNX() {
+// Get (blocking) returns a copy of a cached answer with TTLs decremented by the
+// time the answer has spent in cache. Returns nil if the answer is not in cache
+// or has expired. Answers past their stale window are deleted. The second value
+// is true once for popular answers that are about to expire and should be prefetched
+func (c *Queries) Get(k Key) (*dns.Msg, bool) {
 	c.Lock()
 	defer c.Unlock()
 
-	newMap := make(map[string]time.Time)
+	el, ok := c.resolved[k]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
 
-	for k, v := range c.nx {
-		newMap[k] = v
+	now := time.Now()
+	if !now.Before(e.a.E) {
+		if !now.Before(e.a.E.Add(c.stale)) {
+			c.remove(el)
+		}
+		return nil, false
 	}
 
-	c.nx = nil
-	c.nx = newMap
-}
+	c.lru.MoveToFront(el)
+	e.hits++
 
-// GetTTL return cache.ttl value
-func (c *Queries) GetTTL() int {
-	return c.ttl
-}
+	prefetch := c.prefetchHits > 0 &&
+		!e.prefetching &&
+		e.hits >= c.prefetchHits &&
+		e.a.E.Sub(now) <= e.ttl/10
 
-// Question returns dns.Msg.Question[0].Name from a given dns message
-func (c *Queries) Question(msg *dns.Msg) string {
-	return strings.TrimSuffix(msg.Question[0].Name, ".")
+	if prefetch {
+		e.prefetching = true
+	}
+
+	return decrementTTL(e.a.M, uint32(now.Sub(e.a.T)/time.Second)), prefetch
 }
 
-// Exists (blocking) returns true if a question is in cache
-func (c *Queries) Exists(q string) bool {
+// PrefetchDone (blocking) for reporting that a prefetch of an answer has failed, so that
+// Get can report the answer for prefetching again. Answers that are refreshed by Set are
+// reported again without it
+func (c *Queries) PrefetchDone(k Key) {
 	c.Lock()
 	defer c.Unlock()
 
-	_, ok := c.resolved[q]
-
-	return ok
+	if el, ok := c.resolved[k]; ok {
+		el.Value.(*entry).prefetching = false
+	}
 }
 
-// ExistsNX (blocking) returns true if a question is in NX cache
-func (c *Queries) ExistsNX(q string) bool {
+// GetStale (blocking) returns a copy of a cached answer that has expired less than
+// stale seconds ago. The TTLs of a stale answer are set to 30 seconds (RFC 8767).
+// Answers that have not expired are returned as in Get. Returns nil if there is no answer
+func (c *Queries) GetStale(k Key) *dns.Msg {
 	c.Lock()
 	defer c.Unlock()
 
-	_, ok := c.nx[q]
+	el, ok := c.resolved[k]
+	if !ok {
+		return nil
+	}
 
-	return ok
-}
+	e := el.Value.(*entry)
 
-// HasExpired for checking if a specific question in cache has expired
-func (c *Queries) HasExpired(s string) bool {
-	if !c.Exists(s) {
-		return true
+	now := time.Now()
+	if now.Before(e.a.E) {
+		return decrementTTL(e.a.M, uint32(now.Sub(e.a.T)/time.Second))
 	}
 
-	c.Lock()
-	defer c.Unlock()
+	if !now.Before(e.a.E.Add(c.stale)) {
+		return nil
+	}
 
-	q := c.resolved[s]
+	m := e.a.M.Copy()
+	setTTL(m, staleTTL)
 
-	return time.Since(q.T) > time.Second*time.Duration(c.ttl)
+	return m
 }
 
-// HasExpiredNX for checking if a specific question in NX cache has expired
-func (c *Queries) HasExpiredNX(s string) bool {
-	if !c.ExistsNX(s) {
-		return true
+// Set (blocking) for adding an answer to cache. The answer expires based on its
+// minimum answer TTL, or for negative answers based on the SOA minimum (RFC 2308),
+// capped by cache.ttl. Returns false if the answer can not be cached
+func (c *Queries) Set(msg *dns.Msg) bool {
+	if c.ttl <= 0 || len(msg.Question) == 0 {
+		return false
 	}
 
-	c.Lock()
-	defer c.Unlock()
-
-	q := c.nx[s]
+	ttl, ok := msgTTL(msg)
+	if !ok {
+		return false
+	}
 
-	return time.Since(q) > time.Second*time.Duration(c.ttl)
-}
+	if ttl > uint32(c.ttl) {
+		ttl = uint32(c.ttl)
+	}
 
-// Get for getting a cached question from the cache
-func (c *Queries) Get(s string) *dns.Msg {
-	if c.Exists(s) {
-		return c.resolved[s].M
+	if ttl == 0 {
+		return false
 	}
 
-	return nil
-}
+	now := time.Now()
+	e := newEntry(msg.Copy(), now, now.Add(time.Duration(ttl)*time.Second))
 
-// Register (blocking) for adding a new question to cache
-func (c *Queries) Register(s string, msg *dns.Msg) bool {
-	if c.Exists(s) {
+	if c.maxBytes > 0 && e.size > c.maxBytes {
 		return false
 	}
 
 	c.Lock()
 	defer c.Unlock()
 
-	c.resolved[s] = Answered{
-		M: msg,
-		T: time.Now(),
-	}
+	c.add(e)
 
 	return true
 }
 
-// RegisterNX (blocking) for adding a new question to NX cache
-func (c *Queries) RegisterNX(s string) bool {
-	if c.ExistsNX(s) {
-		return false
-	}
-
+// Expire (blocking) deletes all answers whose stale window has passed since the last call
+// and returns the number of deleted answers. Answers are grouped by the second
+// they expire, so only the groups that are due are visited
+func (c *Queries) Expire() int {
 	c.Lock()
 	defer c.Unlock()
 
-	c.nx[s] = time.Now()
+	now := time.Now()
+	deleted := 0
 
-	return true
-}
+	for ; c.swept <= now.Unix(); c.swept++ {
+		keys, ok := c.expiry[c.swept]
+		if !ok {
+			continue
+		}
+
+		for _, k := range keys {
+			el, ok := c.resolved[k]
+			if !ok {
+				continue
+			}
 
-// Renew (blocking) for updating ttl for a question in cache
-func (c *Queries) Renew(s string, msg *dns.Msg) bool {
-	if !c.Exists(s) {
-		return c.Register(s, msg)
+			// The key may have been replaced by a newer answer that expires later
+			if now.Before(el.Value.(*entry).a.E.Add(c.stale)) {
+				continue
+			}
+
+			c.remove(el)
+			deleted++
+		}
+
+		delete(c.expiry, c.swept)
 	}
 
+	return deleted
+}
+
+// Delete (blocking) for deleting a question from cache
+func (c *Queries) Delete(k Key) {
 	c.Lock()
 	defer c.Unlock()
 
-	e := c.resolved[s]
+	if el, ok := c.resolved[k]; ok {
+		c.remove(el)
+	}
+}
 
-	e.T = time.Now()
+func newEntry(m *dns.Msg, stored, expires time.Time) *entry {
+	e := &entry{
+		key: KeyOf(m),
+		a: Answered{
+			M: m,
+			T: stored,
+			E: expires,
+		},
+		ttl: expires.Sub(stored),
+	}
+	e.size = m.Len() + len(e.key.Name) + entryOverhead
 
-	return true
+	return e
 }
 
-// RenewNX (blocking) for updating a ttl for a question in NX cache
-func (c *Queries) RenewNX(s string) bool {
-	if !c.ExistsNX(s) {
-		return c.RegisterNX(s)
+// add inserts e as the most recently used answer and evicts answers until
+// the cache is within its limits. c must be locked
+func (c *Queries) add(e *entry) {
+	if el, ok := c.resolved[e.key]; ok {
+		c.remove(el)
 	}
 
-	c.Lock()
-	defer c.Unlock()
+	c.resolved[e.key] = c.lru.PushFront(e)
+	c.bytes += e.size
 
-	c.nx[s] = time.Now()
+	// Round up so the group is visited after the answer has expired
+	sec := e.a.E.Add(c.stale).Unix() + 1
+	c.expiry[sec] = append(c.expiry[sec], e.key)
 
-	return true
+	for c.full() {
+		c.remove(c.lru.Back())
+	}
 }
 
-// ExpiredQueries (blocking) for returning all expired questions. Returns empty slice if c.ttl is < 0
-func (c *Queries) ExpiredQueries() []string {
-	if c.ttl < 0 {
-		return []string{}
+func (c *Queries) full() bool {
+	if c.lru.Len() == 0 {
+		return false
 	}
 
-	c.Lock()
-	defer c.Unlock()
-
-	questions := []string{}
-	for h, q := range c.resolved {
-		if time.Since(q.T) > time.Second*time.Duration(c.ttl) {
-			questions = append(questions, h)
-		}
+	if c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		return true
 	}
 
-	return questions
+	return c.maxBytes > 0 && c.bytes > c.maxBytes
+}
+
+func (c *Queries) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.resolved, e.key)
+	c.bytes -= e.size
 }
 
-// ExpiredMXQueries (blocking) for returning all expired NX questions. Returns empty slice if c.ttl is < 0
-func (c *Queries) ExpiredMXQueries() []string {
-	if c.ttl < 0 {
-		return []string{}
+// msgTTL returns the number of seconds a message can be cached. Positive answers
+// use the minimum TTL of the answer section. Negative answers (NXDOMAIN/NODATA)
+// use min(SOA TTL, SOA MINIMUM) from the authority section, and are not cached if
+// there is no SOA
+func msgTTL(msg *dns.Msg) (uint32, bool) {
+	switch {
+	case msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0:
+		return minTTL(msg.Answer), true
+	case msg.Rcode == dns.RcodeSuccess || msg.Rcode == dns.RcodeNameError:
+		for _, rr := range msg.Ns {
+			soa, ok := rr.(*dns.SOA)
+			if !ok {
+				continue
+			}
+			if soa.Minttl < soa.Hdr.Ttl {
+				return soa.Minttl, true
+			}
+			return soa.Hdr.Ttl, true
+		}
 	}
 
-	c.Lock()
-	defer c.Unlock()
+	return 0, false
+}
 
-	questions := []string{}
-	for h, q := range c.nx {
-		if time.Since(q) > time.Second*time.Duration(c.ttl) {
-			questions = append(questions, h)
+func minTTL(rrs []dns.RR) uint32 {
+	var ttl uint32
+	for i, rr := range rrs {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
 		}
 	}
 
-	return questions
+	return ttl
 }
 
-// Delete (blocking) for deleting a question from cache
-func (c *Queries) Delete(h string) {
-	c.Lock()
-	defer c.Unlock()
-	delete(c.resolved, h)
+// decrementTTL returns a copy of msg with all TTLs decremented by d seconds
+func decrementTTL(msg *dns.Msg, d uint32) *dns.Msg {
+	m := msg.Copy()
+
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if h.Ttl > d {
+				h.Ttl -= d
+			} else {
+				h.Ttl = 0
+			}
+		}
+	}
+
+	return m
 }
 
-// DeleteNX (blocking) for deleting a question from NX cache
-func (c *Queries) DeleteNX(h string) {
-	c.Lock()
-	defer c.Unlock()
-	delete(c.nx, h)
+// setTTL sets all TTLs of msg to ttl
+func setTTL(m *dns.Msg, ttl uint32) {
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				rr.Header().Ttl = ttl
+			}
+		}
+	}
 }
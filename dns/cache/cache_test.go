@@ -0,0 +1,401 @@
+package queries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newMsg(t *testing.T, name string, qtype uint16, rcode int, answer, ns []string) *dns.Msg {
+	t.Helper()
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.Response = true
+	m.Rcode = rcode
+
+	for _, s := range answer {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+
+	for _, s := range ns {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Ns = append(m.Ns, rr)
+	}
+
+	return m
+}
+
+// age moves the store and expiry time of a cached answer d into the past
+func age(c *Queries, k Key, d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	e := c.resolved[k].Value.(*entry)
+	e.a.T = e.a.T.Add(-d)
+	e.a.E = e.a.E.Add(-d)
+}
+
+func TestMsgTTL(t *testing.T) {
+	soa := "example.com. 300 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60"
+
+	tests := []struct {
+		name   string
+		rcode  int
+		answer []string
+		ns     []string
+		ttl    uint32
+		ok     bool
+	}{
+		{
+			name:   "minimum answer ttl",
+			answer: []string{"example.com. 300 IN A 192.0.2.1", "example.com. 60 IN A 192.0.2.2"},
+			ttl:    60,
+			ok:     true,
+		},
+		{
+			name:  "nxdomain uses soa minimum",
+			rcode: dns.RcodeNameError,
+			ns:    []string{soa},
+			ttl:   60,
+			ok:    true,
+		},
+		{
+			name:  "nodata uses soa ttl when lower than minimum",
+			rcode: dns.RcodeSuccess,
+			ns:    []string{"example.com. 30 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60"},
+			ttl:   30,
+			ok:    true,
+		},
+		{
+			name:  "negative answer without soa",
+			rcode: dns.RcodeNameError,
+		},
+		{
+			name:   "servfail",
+			rcode:  dns.RcodeServerFailure,
+			answer: []string{"example.com. 300 IN A 192.0.2.1"},
+			ns:     []string{soa},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl, ok := msgTTL(newMsg(t, "example.com.", dns.TypeA, tt.rcode, tt.answer, tt.ns))
+			if ttl != tt.ttl || ok != tt.ok {
+				t.Errorf("msgTTL() = %d, %v, want %d, %v", ttl, ok, tt.ttl, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    int
+		rcode  int
+		answer []string
+		ns     []string
+		cached bool
+		expiry time.Duration
+	}{
+		{
+			name:   "positive answer",
+			ttl:    3600,
+			answer: []string{"example.com. 120 IN A 192.0.2.1"},
+			cached: true,
+			expiry: 120 * time.Second,
+		},
+		{
+			name:   "capped by cache ttl",
+			ttl:    30,
+			answer: []string{"example.com. 120 IN A 192.0.2.1"},
+			cached: true,
+			expiry: 30 * time.Second,
+		},
+		{
+			name:   "negative answer",
+			ttl:    3600,
+			rcode:  dns.RcodeNameError,
+			ns:     []string{"example.com. 300 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60"},
+			cached: true,
+			expiry: 60 * time.Second,
+		},
+		{
+			name:   "zero ttl",
+			ttl:    3600,
+			answer: []string{"example.com. 0 IN A 192.0.2.1"},
+		},
+		{
+			name:  "negative answer without soa",
+			ttl:   3600,
+			rcode: dns.RcodeNameError,
+		},
+		{
+			name:   "cache disabled",
+			ttl:    0,
+			answer: []string{"example.com. 120 IN A 192.0.2.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(tt.ttl, 0, 0, 0, 0)
+			m := newMsg(t, "Example.COM.", dns.TypeA, tt.rcode, tt.answer, tt.ns)
+
+			if got := c.Set(m); got != tt.cached {
+				t.Fatalf("Set() = %v, want %v", got, tt.cached)
+			}
+
+			k := Key{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+			resp, _ := c.Get(k)
+			if (resp != nil) != tt.cached {
+				t.Fatalf("Get() = %v, want cached %v", resp, tt.cached)
+			}
+
+			if !tt.cached {
+				return
+			}
+
+			if resp.Rcode != tt.rcode {
+				t.Errorf("rcode = %d, want %d", resp.Rcode, tt.rcode)
+			}
+
+			c.Lock()
+			e := c.resolved[k].Value.(*entry)
+			expiry := e.a.E.Sub(e.a.T)
+			c.Unlock()
+
+			if expiry != tt.expiry {
+				t.Errorf("expiry = %s, want %s", expiry, tt.expiry)
+			}
+		})
+	}
+}
+
+func TestGetDecrementsTTL(t *testing.T) {
+	c := NewCache(3600, 0, 0, 0, 0)
+	m := newMsg(t, "example.com.", dns.TypeA, dns.RcodeSuccess, []string{
+		"example.com. 120 IN CNAME www.example.com.",
+		"www.example.com. 100 IN A 192.0.2.1",
+	}, nil)
+
+	if !c.Set(m) {
+		t.Fatal("Set() = false")
+	}
+
+	k := KeyOf(m)
+	age(c, k, 30*time.Second)
+
+	resp, _ := c.Get(k)
+	if resp == nil {
+		t.Fatal("Get() = nil")
+	}
+
+	for i, want := range []uint32{90, 70} {
+		if got := resp.Answer[i].Header().Ttl; got != want {
+			t.Errorf("answer %d ttl = %d, want %d", i, got, want)
+		}
+	}
+
+	// The cached answer itself is not modified
+	again, _ := c.Get(k)
+	if again.Answer[0].Header().Ttl != 90 {
+		t.Errorf("cached answer was modified, ttl = %d", again.Answer[0].Header().Ttl)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := NewCache(3600, 0, 0, 60, 0)
+	m := newMsg(t, "example.com.", dns.TypeA, dns.RcodeSuccess, []string{"example.com. 100 IN A 192.0.2.1"}, nil)
+	c.Set(m)
+	k := KeyOf(m)
+
+	age(c, k, 101*time.Second)
+
+	if resp, _ := c.Get(k); resp != nil {
+		t.Fatal("Get() returned an expired answer")
+	}
+
+	stale := c.GetStale(k)
+	if stale == nil {
+		t.Fatal("GetStale() = nil within the stale window")
+	}
+	if ttl := stale.Answer[0].Header().Ttl; ttl != staleTTL {
+		t.Errorf("stale ttl = %d, want %d", ttl, staleTTL)
+	}
+
+	if n := c.Expire(); n != 0 {
+		t.Errorf("Expire() deleted %d answers within the stale window", n)
+	}
+
+	age(c, k, 60*time.Second)
+
+	if c.GetStale(k) != nil {
+		t.Error("GetStale() returned an answer past the stale window")
+	}
+
+	// The answer was grouped at the second its stale window ended before it was
+	// aged, move it to a group that is due
+	c.Lock()
+	due := time.Now().Unix() - 1
+	c.swept = due
+	c.expiry = map[int64][]Key{due: {k}}
+	c.Unlock()
+	if n := c.Expire(); n != 1 {
+		t.Errorf("Expire() = %d, want 1", n)
+	}
+
+	if n, _ := c.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	a := newMsg(t, "Example.COM.", dns.TypeA, dns.RcodeSuccess, nil, nil)
+	b := newMsg(t, "example.com.", dns.TypeA, dns.RcodeSuccess, nil, nil)
+	aaaa := newMsg(t, "example.com.", dns.TypeAAAA, dns.RcodeSuccess, nil, nil)
+
+	if KeyOf(a) != KeyOf(b) {
+		t.Error("keys differ by case")
+	}
+
+	if KeyOf(a) == KeyOf(aaaa) {
+		t.Error("keys do not differ by type")
+	}
+
+	if got := KeyOf(a).String(); got != "example.com. A IN" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	names := []string{"a.example.com.", "b.example.com.", "c.example.com.", "d.example.com."}
+
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   func(size int) int
+		touch      string
+		kept       []string
+	}{
+		{
+			name:       "entry limit evicts least recently set",
+			maxEntries: 2,
+			kept:       []string{"c.example.com.", "d.example.com."},
+		},
+		{
+			name:       "entry limit keeps recently used",
+			maxEntries: 3,
+			touch:      "a.example.com.",
+			kept:       []string{"a.example.com.", "c.example.com.", "d.example.com."},
+		},
+		{
+			name:     "byte budget",
+			maxBytes: func(size int) int { return 2*size + size/2 },
+			kept:     []string{"c.example.com.", "d.example.com."},
+		},
+		{
+			name:     "byte budget keeps recently used",
+			maxBytes: func(size int) int { return 3 * size },
+			touch:    "b.example.com.",
+			kept:     []string{"b.example.com.", "c.example.com.", "d.example.com."},
+		},
+		{
+			name: "no limits",
+			kept: names,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := []*dns.Msg{}
+			for _, n := range names {
+				msgs = append(msgs, newMsg(t, n, dns.TypeA, dns.RcodeSuccess, []string{n + " 300 IN A 192.0.2.1"}, nil))
+			}
+
+			// All names have the same length, so every entry has the same size
+			size := newEntry(msgs[0], time.Now(), time.Now()).size
+
+			maxBytes := 0
+			if tt.maxBytes != nil {
+				maxBytes = tt.maxBytes(size)
+			}
+			c := NewCache(3600, tt.maxEntries, maxBytes, 0, 0)
+
+			for i, m := range msgs {
+				if !c.Set(m) {
+					t.Fatalf("Set(%s) = false", names[i])
+				}
+				// Touch after the first three answers, before the last one is added
+				if i == 2 && tt.touch != "" {
+					if r, _ := c.Get(Key{Name: tt.touch, Qtype: dns.TypeA, Qclass: dns.ClassINET}); r == nil {
+						t.Fatalf("Get(%s) = nil", tt.touch)
+					}
+				}
+			}
+
+			kept := map[string]bool{}
+			for _, n := range tt.kept {
+				kept[n] = true
+			}
+
+			for _, n := range names {
+				r, _ := c.Get(Key{Name: n, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+				if (r != nil) != kept[n] {
+					t.Errorf("%s cached = %v, want %v", n, r != nil, kept[n])
+				}
+			}
+
+			n, bytes := c.Len()
+			if n != len(tt.kept) || bytes != len(tt.kept)*size {
+				t.Errorf("Len() = %d, %d, want %d, %d", n, bytes, len(tt.kept), len(tt.kept)*size)
+			}
+		})
+	}
+}
+
+func TestSetTooLarge(t *testing.T) {
+	m := newMsg(t, "example.com.", dns.TypeA, dns.RcodeSuccess, []string{"example.com. 300 IN A 192.0.2.1"}, nil)
+	size := newEntry(m, time.Now(), time.Now()).size
+
+	c := NewCache(3600, 0, size-1, 0, 0)
+	if c.Set(m) {
+		t.Error("Set() cached an answer larger than the byte budget")
+	}
+
+	c = NewCache(3600, 0, size, 0, 0)
+	if !c.Set(m) {
+		t.Error("Set() did not cache an answer that fits the byte budget")
+	}
+}
+
+func TestSetReplaces(t *testing.T) {
+	c := NewCache(3600, 0, 0, 0, 0)
+	first := newMsg(t, "example.com.", dns.TypeA, dns.RcodeSuccess, []string{"example.com. 300 IN A 192.0.2.1"}, nil)
+	second := newMsg(t, "example.com.", dns.TypeA, dns.RcodeSuccess, []string{
+		"example.com. 300 IN A 192.0.2.1",
+		"example.com. 300 IN A 192.0.2.2",
+	}, nil)
+
+	c.Set(first)
+	c.Set(second)
+
+	n, bytes := c.Len()
+	if want := newEntry(second, time.Now(), time.Now()).size; n != 1 || bytes != want {
+		t.Errorf("Len() = %d, %d, want 1, %d", n, bytes, want)
+	}
+
+	r, _ := c.Get(KeyOf(second))
+	if r == nil || len(r.Answer) != 2 {
+		t.Errorf("Get() = %v, want the second answer", r)
+	}
+}
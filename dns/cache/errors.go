@@ -0,0 +1,5 @@
+package queries
+
+var (
+	errSnapshotVersion string = "[Cache] snapshot version %d is not supported, expected %d"
+)
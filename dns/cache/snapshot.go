@@ -0,0 +1,150 @@
+package queries
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// snapshotVersion is the version of the on-disk cache format. Bump it when
+// the format changes, snapshots with a different version are not loaded
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version int             `json:"version"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+// snapshotEntry holds a cached answer in wire format along with the time it was stored
+// and the time it expires, so that TTLs keep being decremented after a restart
+type snapshotEntry struct {
+	Msg     []byte    `json:"msg"`
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+}
+
+// Save (blocking) writes all cached answers that have not expired to path. The file
+// is written to a temporary file first and then renamed, so a crash while saving
+// does not corrupt a previous snapshot. Returns the number of saved answers
+func (c *Queries) Save(path string) (int, error) {
+	now := time.Now()
+	snap := snapshot{Version: snapshotVersion}
+
+	// Answers are packed while the cache is locked, since packing can change a
+	// message. Only the file is written without the lock
+	c.Lock()
+	snap.Entries = make([]snapshotEntry, 0, c.lru.Len())
+	// Oldest first, so that Load restores the same LRU order
+	for el := c.lru.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*entry)
+		if !now.Before(e.a.E) {
+			continue
+		}
+
+		data, err := e.a.M.Pack()
+		if err != nil {
+			continue
+		}
+
+		snap.Entries = append(snap.Entries, snapshotEntry{
+			Msg:     data,
+			Stored:  e.a.T,
+			Expires: e.a.E,
+		})
+	}
+	c.Unlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(snap.Entries), nil
+}
+
+// Load (blocking) reads a snapshot written by Save and adds its answers to cache.
+// Answers that have expired are dropped. A missing file is not an error.
+// Returns the number of loaded answers
+func (c *Queries) Load(path string) (int, error) {
+	if c.ttl <= 0 {
+		return 0, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var snap snapshot
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return 0, err
+	}
+
+	if snap.Version != snapshotVersion {
+		return 0, fmt.Errorf(errSnapshotVersion, snap.Version, snapshotVersion)
+	}
+
+	now := time.Now()
+	maxExpires := now.Add(time.Duration(c.ttl) * time.Second)
+
+	c.Lock()
+	defer c.Unlock()
+
+	loaded := 0
+	for _, se := range snap.Entries {
+		if !now.Before(se.Expires) {
+			continue
+		}
+
+		// The cache ttl may have been lowered since the snapshot was written
+		if se.Expires.After(maxExpires) {
+			se.Expires = maxExpires
+		}
+
+		m := new(dns.Msg)
+		if err := m.Unpack(se.Msg); err != nil || len(m.Question) == 0 {
+			continue
+		}
+
+		e := newEntry(m, se.Stored, se.Expires)
+		if c.maxBytes > 0 && e.size > c.maxBytes {
+			continue
+		}
+
+		c.add(e)
+		loaded++
+	}
+
+	return loaded, nil
+}
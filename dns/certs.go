@@ -0,0 +1,181 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const certWatchInterval = 10 * time.Second
+
+// certFile holds tls material (a key pair or a CA pool) loaded from files. The files
+// are watched by CertsBackground and the material is swapped atomically when they change,
+// so new tls handshakes use the new material while established connections are kept
+type certFile struct {
+	files    []string
+	modTimes []time.Time
+	parse    func(files []string) (interface{}, error)
+	value    atomic.Value
+}
+
+func newCertFile(parse func(files []string) (interface{}, error), files ...string) (*certFile, error) {
+	c := &certFile{
+		files:    files,
+		modTimes: make([]time.Time, len(files)),
+		parse:    parse,
+	}
+
+	if _, err := c.reload(true); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newKeyPair loads a certificate and its private key
+func newKeyPair(cert, key string) (*certFile, error) {
+	return newCertFile(func(files []string) (interface{}, error) {
+		cert, err := tls.LoadX509KeyPair(files[0], files[1])
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}, cert, key)
+}
+
+// newCaPool loads a CA certificate file into a cert pool
+func newCaPool(ca string) (*certFile, error) {
+	return newCertFile(func(files []string) (interface{}, error) {
+		certPool, err := loadClientCaCert(files[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(certPool.Subjects()) == 0 {
+			return nil, fmt.Errorf(errNoCertificates, files[0])
+		}
+		return certPool, nil
+	}, ca)
+}
+
+// certificate returns the current key pair
+func (c *certFile) certificate() *tls.Certificate {
+	return c.value.Load().(*tls.Certificate)
+}
+
+// pool returns the current CA pool
+func (c *certFile) pool() *x509.CertPool {
+	return c.value.Load().(*x509.CertPool)
+}
+
+// reload parses the files again if any of them has changed since the last successful
+// load. On error the current material is kept and the files are tried again on the
+// next call. Returns true if the material was replaced
+func (c *certFile) reload(force bool) (bool, error) {
+	modTimes := make([]time.Time, len(c.files))
+	changed := force
+
+	for i, file := range c.files {
+		f, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = f.ModTime()
+		if !modTimes[i].Equal(c.modTimes[i]) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	v, err := c.parse(c.files)
+	if err != nil {
+		return false, err
+	}
+
+	c.value.Store(v)
+	c.modTimes = modTimes
+
+	return true, nil
+}
+
+// certFiles returns the tls material of all listeners and upstreams
+func (s *Server) certFiles() []*certFile {
+	certs := []*certFile{}
+	for _, ln := range s.listeners {
+		if ln.cert != nil {
+			certs = append(certs, ln.cert)
+		}
+		if ln.clientCA != nil {
+			certs = append(certs, ln.clientCA)
+		}
+	}
+
+	for _, u := range s.upstreams() {
+		if u.caPool != nil {
+			certs = append(certs, u.caPool)
+		}
+		if u.clientCert != nil {
+			certs = append(certs, u.clientCert)
+		}
+	}
+
+	return certs
+}
+
+// CertsBackground spawns a goroutine that reloads listener certificates, client CAs and
+// upstream CAs and client certificates when their files change
+func (s *Server) CertsBackground() *ServiceContext {
+	certsContext := &ServiceContext{}
+
+	var serviceWG sync.WaitGroup
+	certsContext.wg = &serviceWG
+
+	ctx, cancel := context.WithCancel(context.Background())
+	certsContext.cancel = cancel
+
+	l := s.logger.WithFields(logrus.Fields{
+		"Component": "DNS Server",
+		"Stage":     "TLS",
+	})
+
+	serviceWG.Add(1)
+	go func(ctx context.Context, wg *sync.WaitGroup, l *logrus.Entry) {
+		ticker := time.NewTicker(certWatchInterval)
+		defer ticker.Stop()
+
+		certs := s.certFiles()
+
+		for {
+			select {
+			case <-ctx.Done():
+				l.WithField("Stage", "Term").Info("Bye!")
+				wg.Done()
+				return
+			case <-ticker.C:
+				for _, c := range certs {
+					files := strings.Join(c.files, ", ")
+					reloaded, err := c.reload(false)
+					if err != nil {
+						l.Errorf(errCertReload, files, err)
+						continue
+					}
+					if reloaded {
+						l.Infof(infoCertReloaded, files)
+					}
+				}
+			}
+		}
+	}(ctx, &serviceWG, l)
+
+	return certsContext
+}
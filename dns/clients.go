@@ -0,0 +1,183 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ClientDenyRefused answers queries from denied clients with REFUSED
+	ClientDenyRefused = "refused"
+	// ClientDenyNXDomain answers queries from denied clients with NXDOMAIN
+	ClientDenyNXDomain = "nxdomain"
+	// ClientDenyServFail answers queries from denied clients with SERVFAIL
+	ClientDenyServFail = "servfail"
+	// ClientDenyDrop does not answer queries from denied clients. DoH clients get http 403
+	ClientDenyDrop = "drop"
+
+	edeTextClientDenied = "client is not allowed to use NetTrust"
+)
+
+// ClientAccess controls which clients can use NetTrust. Queries from clients in Deny, or
+// from clients that are not in Allow when Allow is set, are answered based on DenyResponse
+// (refused, nxdomain, servfail, drop). If Authorize is set, only answers to clients in
+// Authorize networks are passed to the authorizer, other clients are answered without
+// authorizing anything in the firewall. Entries are networks in CIDR notation or single IPs
+type ClientAccess struct {
+	Allow, Deny, Authorize []string
+	DenyResponse           string
+}
+
+// clientACL is a parsed ClientAccess
+type clientACL struct {
+	allow, deny, authorize []*net.IPNet
+	denyResponse           string
+}
+
+func newClientACL(c ClientAccess) (*clientACL, error) {
+	acl := &clientACL{denyResponse: c.DenyResponse}
+
+	switch c.DenyResponse {
+	case "":
+		acl.denyResponse = ClientDenyRefused
+	case ClientDenyRefused, ClientDenyNXDomain, ClientDenyServFail, ClientDenyDrop:
+	default:
+		return nil, fmt.Errorf(errClientDenyResponse, c.DenyResponse)
+	}
+
+	var err error
+	for _, n := range []struct {
+		networks []string
+		dst      *[]*net.IPNet
+	}{
+		{c.Allow, &acl.allow},
+		{c.Deny, &acl.deny},
+		{c.Authorize, &acl.authorize},
+	} {
+		*n.dst, err = parseNetworks(n.networks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return acl, nil
+}
+
+// parseNetworks parses CIDR networks. Single IPs are converted to /32 or /128 networks
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, n := range networks {
+		n = strings.TrimSpace(n)
+
+		if !strings.Contains(n, "/") {
+			ip := net.ParseIP(n)
+			if ip == nil {
+				return nil, fmt.Errorf(errClientNetwork, n)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, fmt.Errorf(errClientNetwork, n)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowed reports if a client can use NetTrust. Deny networks take precedence over allow networks
+func (a *clientACL) allowed(ip net.IP) bool {
+	if ip == nil {
+		return len(a.allow) == 0 && len(a.deny) == 0
+	}
+
+	if containsIP(a.deny, ip) {
+		return false
+	}
+
+	return len(a.allow) == 0 || containsIP(a.allow, ip)
+}
+
+// authorizes reports if answers to a client should be passed to the authorizer
+func (a *clientACL) authorizes(ip net.IP) bool {
+	if len(a.authorize) == 0 {
+		return true
+	}
+
+	return ip != nil && containsIP(a.authorize, ip)
+}
+
+// clientIP returns the IP of the client that sent a query, or nil if it is not known
+func clientIP(w dns.ResponseWriter) net.IP {
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+
+	return nil
+}
+
+// allowClient checks the client of a query against the client access lists before the
+// query is passed to fwd. Denied clients are answered based on the deny response, or
+// not at all in drop mode. Returns false if the query should not be served
+func (s *Server) allowClient(w dns.ResponseWriter, req *dns.Msg) bool {
+	ip := clientIP(w)
+	if s.clients.allowed(ip) {
+		return true
+	}
+
+	question := ""
+	if len(req.Question) > 0 {
+		question = s.cache.Question(req)
+	}
+	s.fwdl.Infof(infoClientDenied, question, w.RemoteAddr())
+
+	if s.clients.denyResponse == ClientDenyDrop {
+		if err := w.Close(); err != nil {
+			s.fwdl.Debug(err)
+		}
+		return false
+	}
+
+	rcode := dns.RcodeRefused
+	switch s.clients.denyResponse {
+	case ClientDenyNXDomain:
+		rcode = dns.RcodeNameError
+	case ClientDenyServFail:
+		rcode = dns.RcodeServerFailure
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(req, rcode)
+	setEDE(m, dns.ExtendedErrorCodeProhibited, edeTextClientDenied)
+
+	err := s.writeMsg(w, req, m)
+	if err != nil {
+		s.fwdl.Error(err)
+	}
+
+	return false
+}
@@ -1,117 +1,166 @@
 package dns
 
 import (
-	"context"
-	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"sync"
-	"syscall"
+	"sync/atomic"
 
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
 	qc "github.com/ulfox/nettrust/dns/cache"
+	"github.com/ulfox/nettrust/dns/domains"
+	"github.com/ulfox/nettrust/dns/zones"
+)
+
+const (
+	// WhitelistModeOff disables domain whitelisting, all domains that are not blacklisted are forwarded
+	WhitelistModeOff = "off"
+	// WhitelistModeNXDomain answers NXDOMAIN to queries for domains that are not whitelisted
+	WhitelistModeNXDomain = "nxdomain"
+	// WhitelistModeRefused answers REFUSED to queries for domains that are not whitelisted
+	WhitelistModeRefused = "refused"
 )
 
 // Server defines NetTrust DNS Server proxy. The server invokes firewall calls also
 type Server struct {
 	sync.Mutex
 
-	listenAddr, fwdAddr, fwdProto string
-	listenTLS, fwdTLS, sigOnce    bool
-	dnsTTLCache                   int
-	clientUDPBufferSize           uint16
-	listenCerts                   *tls.Certificate
-	logger                        *logrus.Logger
-	fwdl                          *logrus.Entry
-	client                        *dns.Client
-	udpServer, tcpServer          *dns.Server
-	cancelOnErr                   context.CancelFunc
-	ctxOnErr                      context.Context
-	cache                         *qc.Queries
-	cacheContext                  *ServiceContext
-	domainBlacklist               map[string]struct{}
+	dnsTTLCache     int
+	listeners       []*listener
+	clients         *clientACL
+	limiter         *rateLimiter
+	logger          *logrus.Logger
+	fwdl            *logrus.Entry
+	forwarder       *forwarder
+	forwardRules    []*forwardRule
+	health          UpstreamHealth
+	udpSize         uint16
+	cache           *qc.Queries
+	cacheContext    *ServiceContext
+	cacheFile       string
+	cacheSave       int
+	inflight        *flightGroup
+	domainBlacklist atomic.Value
+	blocklists      *blocklists
+	blocked         *blockedResponse
+	domainWhitelist *domains.Matcher
+	whitelistMode   string
+	filterAAAA      *filterAAAA
+	zones           *zones.Zones
+	zonesAuthorize  bool
+	recorder        func(resp *dns.Msg, client net.IP)
 }
 
-// NewDNSServer for creating a new NetTrust DNS Server proxy
+// NewDNSServer for creating a new NetTrust DNS Server proxy. Queries are received on the
+// given listeners from the clients allowed by clientAccess, limited by rateLimit, and forwarded to the given upstreams based on fwdStrategy
+// (failover, round-robin, lowest-latency, parallel). Upstreams that keep failing are skipped based
+// on upstreamHealth, see HealthBackground for active probes. Queries that match a forward rule are sent to
+// the rule's upstreams instead. Answers are cached for at most dnsTTLCache seconds, the cache is bounded
+// by dnsCacheMaxEntries and dnsCacheMaxBytes. Expired answers are served for dnsCacheServeStale seconds
+// if upstream fails, answers with dnsCachePrefetch hits are refreshed before they expire. If dnsCacheFile is set,
+// the cache is loaded from it on start and saved to it every dnsCacheSaveInterval seconds and on exit. Blacklisted domains are read from domainBlacklist
+// and from blocklists, see BlocklistsBackground for reloading lists. Blacklisted queries are answered based
+// on blockedResponse (nxdomain, refused, nodata, null, sinkhole, servfail), sinkhole answers use the IPs in
+// blockedSinkhole. If domainWhitelistMode is set (nxdomain/refused),
+// only queries for domains in domainWhitelist are forwarded. AAAA records are filtered from answers based on
+// filterAAAA and the listener's filter mode, see FilterAAAA. Queries for names in localZones are answered
+// locally and are passed to the authorizer only if zonesAuthorize is true. Upstreams are asked with an
+// EDNS0 buffer of clientUDPBufferSize, clients are answered with a buffer of at most listenUDPBufferSize
 func NewDNSServer(
-	laddr, faddr, fwdProto, listenCert, ListenCertKey, clientCaCert string,
-	listenTLS, fwdTLS bool,
+	listeners []Listener,
+	clientAccess ClientAccess,
+	rateLimit RateLimit,
+	upstreams []Upstream,
+	fwdStrategy string,
+	upstreamHealth UpstreamHealth,
+	forwardRules []ForwardRule,
 	dnsTTLCache int,
+	dnsCacheMaxEntries int,
+	dnsCacheMaxBytes int,
+	dnsCacheServeStale int,
+	dnsCachePrefetch int,
+	dnsCacheFile string,
+	dnsCacheSaveInterval int,
 	clientUDPBufferSize uint16,
+	listenUDPBufferSize uint16,
 	domainBlacklist []string,
+	blocklists []Blocklist,
+	blocklistRefresh int,
+	blockedResponse string,
+	blockedSinkhole []string,
+	domainWhitelist []string,
+	domainWhitelistMode string,
+	filterAAAA FilterAAAA,
+	localZones []zones.Zone,
+	zonesAuthorize bool,
 	logger *logrus.Logger,
 ) (*Server, error) {
 
-	if laddr == "" || faddr == "" {
-		return nil, fmt.Errorf(errFWDNSAddr)
+	if _, err := domains.NewMatcher(domainBlacklist); err != nil {
+		return nil, err
 	}
 
-	if fwdTLS && fwdProto != "tcp" {
-		return nil, fmt.Errorf(errFWDTLS)
+	dW, err := domains.NewMatcher(domainWhitelist)
+	if err != nil {
+		return nil, err
 	}
 
-	if fwdProto != "udp" && fwdProto != "tcp" {
-		return nil, fmt.Errorf(errFWDNSProto)
+	lz, err := zones.NewZones(localZones)
+	if err != nil {
+		return nil, err
 	}
 
-	host, port, err := net.SplitHostPort(faddr)
+	clients, err := newClientACL(clientAccess)
 	if err != nil {
 		return nil, err
 	}
 
-	if host == "" || port == "" {
-		return nil, fmt.Errorf(errFWDNSAddrInvalid, host, port)
+	fAAAA, err := newFilterAAAA(filterAAAA, listeners)
+	if err != nil {
+		return nil, err
 	}
 
-	if fwdTLS && port == "53" {
-		logger.Warn(warnFWDTLSPort)
+	blocked, err := newBlockedResponse(blockedResponse, blockedSinkhole)
+	if err != nil {
+		return nil, err
 	}
 
-	client := &dns.Client{Net: fwdProto, UDPSize: uint16(clientUDPBufferSize)}
-	// client := &dns.Client{Net: fwdProto}
-	if fwdTLS {
-		client.Net = "tcp-tls"
+	switch domainWhitelistMode {
+	case "", WhitelistModeOff:
+		domainWhitelistMode = ""
+	case WhitelistModeNXDomain, WhitelistModeRefused:
+	default:
+		return nil, fmt.Errorf(errWhitelistMode, domainWhitelistMode)
 	}
 
-	dB := make(map[string]struct{}, len(domainBlacklist))
+	upstreamHealth = upstreamHealth.withDefaults()
 
-	for _, j := range domainBlacklist {
-		dB[j] = struct{}{}
-	}
-
-	if fwdTLS && clientCaCert != "" {
-		certPool, err := loadClientCaCert(clientCaCert)
-		if err != nil {
-			return nil, err
-		}
-		client.TLSConfig = &tls.Config{
-			RootCAs: certPool,
-		}
+	if listenUDPBufferSize < dns.MinMsgSize {
+		listenUDPBufferSize = defaultListenUDPSize
 	}
 
 	server := &Server{
-		listenAddr:      laddr,
-		listenTLS:       listenTLS,
-		fwdAddr:         faddr,
-		fwdProto:        fwdProto,
-		fwdTLS:          fwdTLS,
 		dnsTTLCache:     dnsTTLCache,
+		clients:         clients,
+		limiter:         newRateLimiter(rateLimit),
+		health:          upstreamHealth,
+		udpSize:         listenUDPBufferSize,
 		logger:          logger,
-		client:          client,
-		cache:           qc.NewCache(dnsTTLCache),
-		domainBlacklist: dB,
-	}
-
-	if listenTLS {
-		cert, err := tls.LoadX509KeyPair(listenCert, ListenCertKey)
-		if err != nil {
-			return nil, err
-		}
-		server.listenCerts = &cert
+		cache:           qc.NewCache(dnsTTLCache, dnsCacheMaxEntries, dnsCacheMaxBytes, dnsCacheServeStale, dnsCachePrefetch),
+		cacheFile:       dnsCacheFile,
+		cacheSave:       dnsCacheSaveInterval,
+		inflight:        newFlightGroup(),
+		blocklists:      newBlocklists(domainBlacklist, blocklists, blocklistRefresh, logger),
+		blocked:         blocked,
+		domainWhitelist: dW,
+		whitelistMode:   domainWhitelistMode,
+		filterAAAA:      fAAAA,
+		zones:           lz,
+		zonesAuthorize:  zonesAuthorize,
 	}
 
 	server.fwdl = server.logger.WithFields(logrus.Fields{
@@ -119,7 +168,52 @@ func NewDNSServer(
 		"Stage":     "Forward",
 	})
 
-	server.ctxOnErr, server.cancelOnErr = context.WithCancel(context.Background())
+	server.forwarder, err = newForwarder(
+		upstreams,
+		fwdStrategy,
+		clientUDPBufferSize,
+		upstreamHealth,
+		server.fwdl,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	server.forwardRules, err = newForwardRules(
+		forwardRules,
+		clientUDPBufferSize,
+		upstreamHealth,
+		server.fwdl,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	server.listeners, err = newListeners(listeners, server.logger.WithFields(logrus.Fields{
+		"Component": "DNS Server",
+		"Stage":     "TLS",
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	if lz.Len() > 0 {
+		server.fwdl.Infof(infoZonesLoaded, lz.Len())
+	}
+
+	err = server.reloadBlacklist(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if dnsCacheFile != "" {
+		n, err := server.cache.Load(dnsCacheFile)
+		if err != nil {
+			server.fwdl.Warnf(warnCacheLoad, dnsCacheFile, err)
+		} else {
+			server.fwdl.Infof(infoCacheLoaded, n, dnsCacheFile)
+		}
+	}
 
 	server.cacheContext, err = server.dnsTTLCacheManager()
 	if err != nil {
@@ -149,19 +243,3 @@ func loadClientCaCert(ca string) (*x509.CertPool, error) {
 
 	return certPool, nil
 }
-
-func (s *Server) killOnErr() {
-	if s.sigOnce {
-		return
-	}
-
-	s.Lock()
-	s.sigOnce = true
-	s.Unlock()
-
-	s.fwdl.Error("nettrust is shutting down, sending SIGINT")
-	err := syscall.Kill(syscall.Getegid(), syscall.SIGINT)
-	if err != nil {
-		s.fwdl.Error(err)
-	}
-}
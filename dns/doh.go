@@ -0,0 +1,268 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dohMediaType   = "application/dns-message"
+	dohDefaultPath = "/dns-query"
+	dohTimeout     = 5 * time.Second
+	dohMaxMsgSize  = 65535
+)
+
+// dohClient for exchanging dns messages with a DNS-over-HTTPS (RFC 8484) upstream
+type dohClient struct {
+	url    string
+	method string
+	client *http.Client
+}
+
+// newDoHClient creates a DoH client. The transport always dials addr, even when serverName is set,
+// this way the upstream address stays the one that has been whitelisted in the firewall
+func newDoHClient(addr, path, method, serverName string, timeout time.Duration, tlsConfig *tls.Config) (*dohClient, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if serverName != "" {
+		host = serverName
+	}
+
+	if path == "" {
+		path = dohDefaultPath
+	}
+
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	if method != http.MethodGet && method != http.MethodPost {
+		return nil, fmt.Errorf(errDoHMethod, method)
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig.ServerName = host
+
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   90 * time.Second,
+		MaxIdleConns:      16,
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+
+	return &dohClient{
+		url:    "https://" + net.JoinHostPort(host, port) + path,
+		method: method,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+	}, nil
+}
+
+// Exchange sends a dns message to the DoH upstream. The address argument is ignored
+// since the client has been bound to its upstream on creation
+func (c *dohClient) Exchange(m *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	// RFC 8484 4.1: use id 0 to make GET requests cache friendly
+	q := m.Copy()
+	q.Id = 0
+
+	buf, err := q.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var req *http.Request
+	if c.method == http.MethodGet {
+		req, err = http.NewRequest(
+			http.MethodGet,
+			c.url+"?dns="+base64.RawURLEncoding.EncodeToString(buf),
+			nil,
+		)
+	} else {
+		req, err = http.NewRequest(http.MethodPost, c.url, bytes.NewReader(buf))
+		if req != nil {
+			req.Header.Set("Content-Type", dohMediaType)
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", dohMediaType)
+
+	t := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf(errDoHStatus, c.url, resp.StatusCode)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); !isDoHMediaType(ct) {
+		return nil, 0, fmt.Errorf(errDoHContentType, c.url, ct)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, dohMaxMsgSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	rtt := time.Since(t)
+
+	r := new(dns.Msg)
+	err = r.Unpack(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.Id = m.Id
+
+	return r, rtt, nil
+}
+
+// dohResponseWriter implements dns.ResponseWriter on top of an http.ResponseWriter
+// so DoH queries can be served by Server.fwd like any other query
+type dohResponseWriter struct {
+	w             http.ResponseWriter
+	local, remote net.Addr
+	tls           *tls.ConnectionState
+	wroteHeader   bool
+}
+
+// LocalAddr returns the address the DoH listener accepted the request on
+func (d *dohResponseWriter) LocalAddr() net.Addr {
+	return d.local
+}
+
+// RemoteAddr returns the address of the DoH client
+func (d *dohResponseWriter) RemoteAddr() net.Addr {
+	return d.remote
+}
+
+// ConnectionState returns the tls state of the DoH connection, it implements dns.ConnectionStater
+func (d *dohResponseWriter) ConnectionState() *tls.ConnectionState {
+	return d.tls
+}
+
+// WriteMsg packs and writes a dns message as the http response body
+func (d *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	buf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Write(buf)
+	return err
+}
+
+// Write writes a packed dns message as the http response body
+func (d *dohResponseWriter) Write(b []byte) (int, error) {
+	if !d.wroteHeader {
+		d.w.Header().Set("Content-Type", dohMediaType)
+		d.w.WriteHeader(http.StatusOK)
+		d.wroteHeader = true
+	}
+
+	return d.w.Write(b)
+}
+
+// Close is a noop, the http server manages the connection
+func (d *dohResponseWriter) Close() error {
+	return nil
+}
+
+// TsigStatus is not supported over DoH
+func (d *dohResponseWriter) TsigStatus() error {
+	return nil
+}
+
+// TsigTimersOnly is not supported over DoH
+func (d *dohResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack is not supported over DoH
+func (d *dohResponseWriter) Hijack() {}
+
+// dohHandler returns an http handler that reads RFC 8484 GET/POST queries and
+// serves them through Server.fwd
+func (s *Server) dohHandler(ln *listener, fn func(resp *dns.Msg, client net.IP) error) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(dohDefaultPath, func(w http.ResponseWriter, r *http.Request) {
+		var buf []byte
+		var err error
+
+		switch r.Method {
+		case http.MethodGet:
+			buf, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+			if err != nil || len(buf) == 0 {
+				http.Error(w, errDoHBadQuery, http.StatusBadRequest)
+				return
+			}
+		case http.MethodPost:
+			if !isDoHMediaType(r.Header.Get("Content-Type")) {
+				http.Error(w, errDoHBadQuery, http.StatusUnsupportedMediaType)
+				return
+			}
+			buf, err = ioutil.ReadAll(io.LimitReader(r.Body, dohMaxMsgSize))
+			if err != nil {
+				http.Error(w, errDoHBadQuery, http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, errDoHBadQuery, http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := new(dns.Msg)
+		if err := req.Unpack(buf); err != nil {
+			http.Error(w, errDoHBadQuery, http.StatusBadRequest)
+			return
+		}
+
+		rw := &dohResponseWriter{w: w, tls: r.TLS}
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			rw.local = addr
+		}
+		if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+			rw.remote = addr
+		}
+
+		if !s.allowClient(rw, req) || !s.limitClient(rw, req) {
+			if !rw.wroteHeader {
+				http.Error(w, errDoHForbidden, http.StatusForbidden)
+			}
+			return
+		}
+
+		s.fwd(rw, req, ln, fn)
+	})
+
+	return mux
+}
+
+// isDoHMediaType reports if a Content-Type header is the DoH media type. Parameters
+// such as charset are ignored
+func isDoHMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == dohMediaType
+}
@@ -0,0 +1,144 @@
+package domains
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// node is a label in the suffix trie. Labels are stored from the
+// top level domain down, e.g. com -> example -> tracker
+type node struct {
+	children map[string]*node
+	exact    bool
+	wildcard bool
+}
+
+// Matcher for matching domains against exact names, *.suffix wildcards and
+// regular expressions. Exact names and wildcards are kept in a suffix trie, so
+// lookups cost one map access per label regardless of the number of entries.
+// A Matcher must not be modified while it is being used for lookups
+type Matcher struct {
+	root    *node
+	regexps []*regexp.Regexp
+	size    int
+}
+
+// NewMatcher creates a new Matcher from a list of entries. See Matcher.Add for the entry syntax
+func NewMatcher(entries []string) (*Matcher, error) {
+	m := &Matcher{
+		root: &node{children: make(map[string]*node)},
+	}
+
+	for _, e := range entries {
+		if err := m.Add(e); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Add adds an entry to the matcher. Supported entries:
+//
+//	example.com     matches only example.com
+//	*.example.com   matches any subdomain of example.com but not example.com
+//	/^ads?\d+\./    regular expression, matched against the full domain
+func (m *Matcher) Add(entry string) error {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return nil
+	}
+
+	if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+		re, err := regexp.Compile(entry[1 : len(entry)-1])
+		if err != nil {
+			return fmt.Errorf(errInvalidRegex, entry, err)
+		}
+		m.regexps = append(m.regexps, re)
+		m.size++
+		return nil
+	}
+
+	wildcard := false
+	if strings.HasPrefix(entry, "*.") {
+		wildcard = true
+		entry = entry[2:]
+	}
+
+	entry = Normalize(entry)
+	if entry == "" || strings.Contains(entry, "*") {
+		return fmt.Errorf(errInvalidEntry, entry)
+	}
+
+	n := m.root
+	labels := strings.Split(entry, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := n.children[labels[i]]
+		if !ok {
+			child = &node{children: make(map[string]*node)}
+			n.children[labels[i]] = child
+		}
+		n = child
+	}
+
+	if wildcard {
+		n.wildcard = true
+	} else {
+		n.exact = true
+	}
+	m.size++
+
+	return nil
+}
+
+// Match returns true if domain matches any of the matcher entries
+func (m *Matcher) Match(domain string) bool {
+	if m == nil {
+		return false
+	}
+
+	domain = Normalize(domain)
+	if domain == "" {
+		return false
+	}
+
+	n := m.root
+	labels := strings.Split(domain, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := n.children[labels[i]]
+		if !ok {
+			n = nil
+			break
+		}
+		n = child
+		if n.wildcard && i > 0 {
+			return true
+		}
+	}
+
+	if n != nil && n.exact {
+		return true
+	}
+
+	for _, re := range m.regexps {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of entries in the matcher
+func (m *Matcher) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.size
+}
+
+// Normalize lower cases a domain and removes the trailing dot
+func Normalize(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
@@ -0,0 +1,105 @@
+package domains
+
+import "testing"
+
+func TestMatcherMatch(t *testing.T) {
+	m, err := NewMatcher([]string{
+		"example.com",
+		"*.tracker.net",
+		"Upper.Case.ORG.",
+		"/^ads?[0-9]+\\./",
+		"",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		domain string
+		match  bool
+	}{
+		{"example.com", true},
+		{"example.com.", true},
+		{"EXAMPLE.com", true},
+		{"www.example.com", false},
+		{"com", false},
+		{"tracker.net", false},
+		{"a.tracker.net", true},
+		{"a.b.tracker.net.", true},
+		{"nottracker.net", false},
+		{"upper.case.org", true},
+		{"ad1.example.net", true},
+		{"ads22.example.net", true},
+		{"bad1.example.net", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := m.Match(tt.domain); got != tt.match {
+				t.Errorf("Match(%q) = %v, want %v", tt.domain, got, tt.match)
+			}
+		})
+	}
+
+	if m.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", m.Len())
+	}
+}
+
+func TestMatcherExactAndWildcard(t *testing.T) {
+	m, err := NewMatcher([]string{"example.com", "*.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{"example.com", "www.example.com", "a.b.example.com"} {
+		if !m.Match(d) {
+			t.Errorf("Match(%q) = false, want true", d)
+		}
+	}
+}
+
+func TestMatcherAddInvalid(t *testing.T) {
+	tests := []string{
+		"/[/",
+		"*.",
+		"a.*.com",
+		"*.*.com",
+	}
+
+	for _, entry := range tests {
+		t.Run(entry, func(t *testing.T) {
+			if _, err := NewMatcher([]string{entry}); err == nil {
+				t.Errorf("NewMatcher(%q) did not fail", entry)
+			}
+		})
+	}
+}
+
+func TestNilMatcher(t *testing.T) {
+	var m *Matcher
+	if m.Match("example.com") {
+		t.Error("nil matcher matched")
+	}
+	if m.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", m.Len())
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"Example.COM.", "example.com"},
+		{" example.com ", "example.com"},
+		{"example.com", "example.com"},
+		{".", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.out {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
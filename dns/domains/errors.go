@@ -0,0 +1,10 @@
+package domains
+
+var (
+	errInvalidEntry string = "[%s] is not a valid domain entry"
+	errInvalidRegex string = "[%s] is not a valid regex: %s"
+	errListFormat   string = "list format [%s] is not supported. Supported: auto, hosts, domains, adblock"
+	errListLine     string = "line %d: %s"
+	errListHosts    string = "[%s] is not a valid hosts entry"
+	errListAdblock  string = "[%s] is not a supported adblock rule, only ||domain^ rules are supported"
+)
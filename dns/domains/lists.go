@@ -0,0 +1,193 @@
+package domains
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+const (
+	// FormatAuto detects the format of every line
+	FormatAuto = "auto"
+	// FormatHosts for /etc/hosts style lists, e.g. 0.0.0.0 example.com
+	FormatHosts = "hosts"
+	// FormatDomains for lists with one domain per line
+	FormatDomains = "domains"
+	// FormatAdblock for Adblock style lists, e.g. ||example.com^
+	FormatAdblock = "adblock"
+)
+
+// hostsIgnore are names that are commonly found in hosts files but should never be blocked
+var hostsIgnore = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"0.0.0.0":               {},
+}
+
+// ParseList reads a blocklist and returns its entries in Matcher syntax. Lines that
+// could not be parsed are returned as errors, parsing continues on errors
+func ParseList(r io.Reader, format string) ([]string, []error) {
+	if format == "" {
+		format = FormatAuto
+	}
+
+	entries := []string{}
+	errs := []error{}
+
+	switch format {
+	case FormatAuto, FormatHosts, FormatDomains, FormatAdblock:
+	default:
+		return entries, []error{fmt.Errorf(errListFormat, format)}
+	}
+
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") ||
+			strings.HasPrefix(line, "[") {
+			continue
+		}
+
+		f := format
+		if f == FormatAuto {
+			f = detectFormat(line)
+		}
+
+		var parsed []string
+		var err error
+		switch f {
+		case FormatHosts:
+			parsed, err = parseHostsLine(line)
+		case FormatAdblock:
+			parsed, err = parseAdblockLine(line)
+		default:
+			parsed, err = parseDomainLine(line)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf(errListLine, n, err))
+			continue
+		}
+		entries = append(entries, parsed...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return entries, errs
+}
+
+func detectFormat(line string) string {
+	if strings.HasPrefix(line, "||") || strings.HasPrefix(line, "@@") {
+		return FormatAdblock
+	}
+
+	if fields := strings.Fields(line); len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+		return FormatHosts
+	}
+
+	return FormatDomains
+}
+
+func parseHostsLine(line string) ([]string, error) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return nil, fmt.Errorf(errListHosts, line)
+	}
+
+	entries := []string{}
+	for _, h := range fields[1:] {
+		h = Normalize(h)
+		if _, ok := hostsIgnore[h]; ok {
+			continue
+		}
+		if !isDomain(h) {
+			return nil, fmt.Errorf(errInvalidEntry, h)
+		}
+		entries = append(entries, h)
+	}
+
+	return entries, nil
+}
+
+// parseAdblockLine supports only the domain anchor syntax ||example.com^ which
+// blocks a domain along with all of its subdomains. Options after $ are ignored
+func parseAdblockLine(line string) ([]string, error) {
+	if !strings.HasPrefix(line, "||") {
+		return nil, fmt.Errorf(errListAdblock, line)
+	}
+
+	rule := strings.TrimPrefix(line, "||")
+	if i := strings.Index(rule, "$"); i >= 0 {
+		rule = rule[:i]
+	}
+
+	if !strings.HasSuffix(rule, "^") {
+		return nil, fmt.Errorf(errListAdblock, line)
+	}
+
+	d := Normalize(strings.TrimSuffix(rule, "^"))
+	if !isDomain(d) {
+		return nil, fmt.Errorf(errListAdblock, line)
+	}
+
+	return []string{d, "*." + d}, nil
+}
+
+func parseDomainLine(line string) ([]string, error) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+
+	if len(strings.Fields(line)) != 1 {
+		return nil, fmt.Errorf(errInvalidEntry, line)
+	}
+
+	if strings.HasPrefix(line, "/") {
+		return []string{line}, nil
+	}
+
+	d := Normalize(strings.TrimPrefix(line, "*."))
+	if !isDomain(d) {
+		return nil, fmt.Errorf(errInvalidEntry, line)
+	}
+
+	if strings.HasPrefix(line, "*.") {
+		return []string{"*." + d}, nil
+	}
+
+	return []string{d}, nil
+}
+
+// isDomain is a loose check for names that can be found in blocklists
+func isDomain(d string) bool {
+	if d == "" || len(d) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
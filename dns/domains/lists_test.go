@@ -0,0 +1,144 @@
+package domains
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		list    string
+		entries []string
+		errs    int
+	}{
+		{
+			name:   "hosts",
+			format: FormatHosts,
+			list: `# comment
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # trailing comment
+::1 ip6-localhost
+0.0.0.0 Upper.Example.COM.
+0.0.0.0
+not-an-ip example.com
+0.0.0.0 bad!name`,
+			entries: []string{"ads.example.com", "tracker.example.com", "upper.example.com"},
+			errs:    3,
+		},
+		{
+			name:   "domains",
+			format: FormatDomains,
+			list: `example.com
+*.wild.example.com
+/^ads?[0-9]+\./
+sub.example.com # comment
+
+two words
+bad!name`,
+			entries: []string{"example.com", "*.wild.example.com", `/^ads?[0-9]+\./`, "sub.example.com"},
+			errs:    2,
+		},
+		{
+			name:   "adblock",
+			format: FormatAdblock,
+			list: `! comment
+[Adblock Plus 2.0]
+||ads.example.com^
+||tracker.example.com^$third-party
+example.com
+||path.example.com/ads^
+@@||allowed.example.com^`,
+			entries: []string{"ads.example.com", "*.ads.example.com", "tracker.example.com", "*.tracker.example.com"},
+			errs:    3,
+		},
+		{
+			name:   "auto",
+			format: FormatAuto,
+			list: `0.0.0.0 hosts.example.com
+||adblock.example.com^
+domain.example.com
+@@||allowed.example.com^`,
+			entries: []string{"hosts.example.com", "adblock.example.com", "*.adblock.example.com", "domain.example.com"},
+			errs:    1,
+		},
+		{
+			name:    "default format is auto",
+			list:    "0.0.0.0 hosts.example.com\ndomain.example.com",
+			entries: []string{"hosts.example.com", "domain.example.com"},
+		},
+		{
+			name:    "unsupported format",
+			format:  "csv",
+			list:    "example.com",
+			entries: []string{},
+			errs:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, errs := ParseList(strings.NewReader(tt.list), tt.format)
+			if !reflect.DeepEqual(entries, tt.entries) {
+				t.Errorf("entries = %q, want %q", entries, tt.entries)
+			}
+			if len(errs) != tt.errs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.errs, errs)
+			}
+		})
+	}
+}
+
+func TestParseListEntriesMatch(t *testing.T) {
+	entries, errs := ParseList(strings.NewReader("||ads.example.com^\n0.0.0.0 tracker.example.com"), FormatAuto)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	m, err := NewMatcher(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		domain string
+		match  bool
+	}{
+		{"ads.example.com", true},
+		{"cdn.ads.example.com", true},
+		{"tracker.example.com", true},
+		{"cdn.tracker.example.com", false},
+		{"example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := m.Match(tt.domain); got != tt.match {
+			t.Errorf("Match(%q) = %v, want %v", tt.domain, got, tt.match)
+		}
+	}
+}
+
+func TestIsDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		valid  bool
+	}{
+		{"example.com", true},
+		{"a-b_c.example.com", true},
+		{"xn--bcher-kva.example", true},
+		{"", false},
+		{"example..com", false},
+		{"exa mple.com", false},
+		{"Example.com", false},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("a.", 127) + "com", false},
+	}
+
+	for _, tt := range tests {
+		if got := isDomain(tt.domain); got != tt.valid {
+			t.Errorf("isDomain(%q) = %v, want %v", tt.domain, got, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// defaultListenUDPSize is the EDNS0 buffer size NetTrust advertises to clients, as
+// recommended by DNS Flag Day 2020 to avoid ip fragmentation
+const defaultListenUDPSize = 1232
+
+// upstreamQuery returns a copy of req that advertises NetTrust's own EDNS0 buffer
+// size to upstream. The client's DO bit is kept
+func upstreamQuery(req *dns.Msg, udpSize uint16) *dns.Msg {
+	m := req.Copy()
+
+	if opt := m.IsEdns0(); opt != nil {
+		opt.SetUDPSize(udpSize)
+		return m
+	}
+
+	m.SetEdns0(udpSize, false)
+
+	return m
+}
+
+// setEDE adds an RFC 8914 extended dns error to m. The error is sent only to
+// clients that use EDNS0, see writeMsg
+func setEDE(m *dns.Msg, code uint16, text string) {
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: text})
+	m.Extra = append(m.Extra, opt)
+}
+
+// writeMsg sends resp to the client. The OPT record of the upstream answer is replaced
+// based on the client's EDNS0 (RFC 6891): clients without EDNS0 get no OPT record and
+// clients with EDNS0 get NetTrust's buffer size. Extended errors are kept. Answers over
+// UDP that do not fit in the client's buffer are truncated and have the TC bit set. UDP
+// answers over the response rate limit are dropped or sent empty with the TC bit set.
+// Truncated answers are recorded, so a tcp retry verifies the client's source address
+func (s *Server) writeMsg(w dns.ResponseWriter, req, resp *dns.Msg) error {
+	addr, udp := w.RemoteAddr().(*net.UDPAddr)
+	if udp {
+		send, slip := s.limiter.respond(addr.IP, resp)
+		if !send {
+			s.fwdl.Debugf(infoRRLDropped, addr)
+			return nil
+		}
+		if slip {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			m.Truncated = true
+			s.limiter.truncated(addr.IP)
+			return w.WriteMsg(m)
+		}
+	}
+
+	var ede []dns.EDNS0
+	extra := resp.Extra[:0]
+	for _, rr := range resp.Extra {
+		opt, ok := rr.(*dns.OPT)
+		if !ok {
+			extra = append(extra, rr)
+			continue
+		}
+		for _, o := range opt.Option {
+			if _, ok := o.(*dns.EDNS0_EDE); ok {
+				ede = append(ede, o)
+			}
+		}
+	}
+	resp.Extra = extra
+
+	size := dns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(s.udpSize, opt.Do())
+		resp.IsEdns0().Option = ede
+
+		if opt.UDPSize() > uint16(size) {
+			size = int(opt.UDPSize())
+		}
+		if size > int(s.udpSize) {
+			size = int(s.udpSize)
+		}
+	}
+
+	if udp {
+		resp.Truncate(size)
+		if resp.Truncated {
+			s.limiter.truncated(addr.IP)
+		}
+	}
+
+	return w.WriteMsg(resp)
+}
@@ -3,18 +3,73 @@ package dns
 var (
 	errFWDNSAddr           string = "forward dns host: addr  can not be empty"
 	errFWDNSAddrInvalid    string = "forward dns address is not valid [%s:%s]"
-	errFWDNSProto          string = "forward tcp proto can be either tcp or udp"
+	errFWDNSProto          string = "forward proto can be either tcp, udp or https"
 	errFWDTLS              string = "forward tls requires proto to be tcp"
+	errDoHMethod           string = "[DoH] method [%s] is not supported. Use GET or POST"
+	errDoHStatus           string = "[DoH] upstream %s replied with status %d"
+	errDoHContentType      string = "[DoH] upstream %s replied with content type [%s]"
+	errDoHBadQuery         string = "invalid DoH query"
+	errDoHForbidden        string = "client is not allowed"
+	errBlocklistLoad       string = "[Blocklist] could not load %s: %s"
+	errBlocklistStatus     string = "unexpected http status %d"
+	errBlockedResponse     string = "blocked response [%s] is not supported. Supported: nxdomain, refused, nodata, null, sinkhole, servfail"
+	errBlockedSinkhole     string = "blocked sinkhole [%s] is not a valid ip address"
+	errBlockedNoSinkhole   string = "blocked response is sinkhole but no sinkhole ip was provided"
+	errClientNetwork       string = "client network [%s] is not a valid ip or cidr network"
+	errClientDenyResponse  string = "client deny response [%s] is not supported. Supported: refused, nxdomain, servfail, drop"
+	errWhitelistMode       string = "domain whitelist mode [%s] is not supported. Supported: off, nxdomain, refused"
+	errListenerFailed      string = "%s listener on %s failed: %s"
+	errListenAddr          string = "listen address can not be empty"
+	errNoListener          string = "no %s listener is configured"
+	errListenProto         string = "listen proto [%s] for [%s] is not supported. Supported: udp, tcp, dot, doh"
+	errListenClientAuth    string = "client auth [%s] for [%s] is not supported. Supported: none, request, require, verify-if-given, require-and-verify"
+	errListenClientCa      string = "client auth [%s] for [%s] requires a client ca certificate"
+	errFWDClientCert       string = "upstream [%s] requires both a client certificate and a client key"
+	errForwardRule         string = "forward rules require at least one domain"
+	errFilterAAAAMode      string = "filter aaaa mode [%s] is not supported. Supported: off, strip, nodata"
+	errFilterAAAARule      string = "filter aaaa rules require at least one domain and a mode"
+	errFWDStrategy         string = "forward strategy [%s] is not supported. Supported: failover, round-robin, lowest-latency, parallel"
 	errQuery               string = "invalid query, no questions"
 	errNotAFile            string = "[%s] is a directory"
+	errNoCertificates      string = "no certificates found in [%s]"
+	errCertReload          string = "[TLS] could not reload %s, keeping the loaded certificates: %s"
 	errManyQuestions       string = "[Invalid] query has more than 1 question [%s]"
-	errCacheFetch          string = "[Cache] something went wrong, could not fetch dns object from cache for question: %s"
-	errCacheRegister       string = "[Cache] could not register dns object with question %s to cache"
-	errCacheCoulndNotRenew string = "[Cache] could not renew object for question %s"
+	errCacheSave           string = "[Cache] could not save cache to %s: %s"
 	errNil                 string = "cache has not been initialized, starting ttl cache checker is forbidden"
-	warnFWDTLSPort         string = "forward tls is enabled but port is set to 53"
-	infoCacheObjExpired    string = "[Cache] dns cache object with question %s has expired, asking upstream"
+	warnFWDTLSPort         string = "forward tls is enabled but port is set to 53 for upstream [%s]"
+	warnUpstreamFailed     string = "[Upstream] %s failed: %s"
+	warnServeStale         string = "[Cache] serving stale answer for question %s, upstream failed: %s"
+	warnCachePrefetch      string = "[Cache] could not prefetch question %s: %s"
+	warnCacheLoad          string = "[Cache] could not load cache from %s: %s"
+	warnCircuitState       string = "[Upstream] %s circuit %s -> %s after %d failures: %v"
+	warnAllCircuitsOpen    string = "[Upstream] all upstream circuits are open, asking all upstreams"
+	warnClientBanned       string = "[Banned] client %s reached %d strikes (last: %s), banned for %d seconds"
+	warnBlocklistParse     string = "[Blocklist] %s: %s"
+	warnBlocklistMoreErrs  string = "[Blocklist] %s: %d more parse errors"
+	infoBlocklistLoaded    string = "[Blocklist] %s loaded %d entries (%d parse errors)"
+	infoBlacklistSize      string = "[Blocklist] domain blacklist has %d entries"
 	infoCacheObjFound      string = "[Cache] found dns object in cache for question %s"
-	infoCacheObjFoundNil   string = "[Cache] found dns object in nil cache for question %s"
+	infoCachePrefetched    string = "[Cache] prefetched question %s"
+	infoCacheLoaded        string = "[Cache] loaded %d answers from %s"
+	infoCacheSaved         string = "[Cache] saved %d answers to %s"
+	infoCacheAnswerSkipped string = "[Cache] answer for question %s was not cached, it has no TTL or no SOA"
+	infoQueryCoalesced     string = "[Coalesced] Question %s was answered once for identical queries in flight"
+	infoCircuitState       string = "[Upstream] %s circuit %s -> %s"
+	infoUpstreamStatus     string = "[Upstream] %s circuit %s rtt %s failures %d"
+	infoTruncatedRetryTCP  string = "[Upstream] answer for %s from %s was truncated, retrying over tcp"
+	infoClientIdentity     string = "[mTLS] Question %s from %s, verified client %s"
+	infoTLSClient          string = "[mTLS] verified client %s connected to %s listener %s"
+	infoCertReloaded       string = "[TLS] reloaded %s"
+	infoClientDenied       string = "[Client Denied] Question %s from %s"
+	infoClientNoAuthorize  string = "[Client Not Authorizing] Question %s from %s will not be authorized"
+	infoClientRateLimited  string = "[Rate Limited] query from %s was refused"
+	infoClientBanned       string = "[Banned] query from %s was refused"
+	infoClientBannedDNS    string = "[Banned] client %s is only refused, its source address is not verified or is exempt from firewall bans"
+	infoRRLDropped         string = "[RRL] response to %s was dropped"
 	infoDomainBlacklist    string = "[Blacklisted] Question %s"
+	infoNotWhitelisted     string = "[Not Whitelisted] Question %s"
+	infoAAAAFiltered       string = "[AAAA Filtered] Question %s was answered with NODATA"
+	infoPassThrough        string = "[Pass Through] Question %s was answered without authorization"
+	infoZoneAnswer         string = "[Local] Question %s answered from local zones"
+	infoZonesLoaded        string = "[Local] loaded %d local zone records"
 )
@@ -0,0 +1,218 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+	"github.com/ulfox/nettrust/dns/domains"
+)
+
+const (
+	// FilterAAAAOff sends AAAA records to clients as received from upstream
+	FilterAAAAOff = "off"
+	// FilterAAAAStrip removes AAAA records and SVCB/HTTPS ipv6 hints from answers
+	FilterAAAAStrip = "strip"
+	// FilterAAAANoData answers AAAA queries with NOERROR and an empty answer section
+	// without asking upstream. AAAA records in other answers are stripped
+	FilterAAAANoData = "nodata"
+
+	edeTextAAAAFiltered = "AAAA filtered, IPv6 is not authorized by NetTrust"
+)
+
+// FilterAAAARule sets the filter AAAA mode for names under Domains (the domain itself
+// and all of its subdomains)
+type FilterAAAARule struct {
+	Domains []string
+	Mode    string
+}
+
+// FilterAAAA configures filtering of AAAA records while IPv6 is not authorized in the
+// firewall. Rules are checked in order and the first rule that matches the question is
+// used. If no rule matches, the listener's mode is used, and if the listener has no mode,
+// Mode is used. Supported modes are off, strip and nodata
+type FilterAAAA struct {
+	Mode  string
+	Rules []FilterAAAARule
+}
+
+type filterAAAARule struct {
+	matcher *domains.Matcher
+	mode    string
+}
+
+// filterAAAA is a validated FilterAAAA
+type filterAAAA struct {
+	mode  string
+	rules []*filterAAAARule
+}
+
+func checkFilterAAAAMode(mode string) error {
+	switch mode {
+	case "", FilterAAAAOff, FilterAAAAStrip, FilterAAAANoData:
+		return nil
+	}
+
+	return fmt.Errorf(errFilterAAAAMode, mode)
+}
+
+// newFilterAAAA validates f and the filter modes of listeners
+func newFilterAAAA(f FilterAAAA, listeners []Listener) (*filterAAAA, error) {
+	err := checkFilterAAAAMode(f.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range listeners {
+		err = checkFilterAAAAMode(l.FilterAAAA)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filter := &filterAAAA{mode: f.Mode}
+
+	for _, r := range f.Rules {
+		if len(r.Domains) == 0 || r.Mode == "" {
+			return nil, fmt.Errorf(errFilterAAAARule)
+		}
+
+		err = checkFilterAAAAMode(r.Mode)
+		if err != nil {
+			return nil, err
+		}
+
+		entries := []string{}
+		for _, d := range r.Domains {
+			d = domains.Normalize(d)
+			entries = append(entries, d, "*."+d)
+		}
+
+		m, err := domains.NewMatcher(entries)
+		if err != nil {
+			return nil, err
+		}
+
+		filter.rules = append(filter.rules, &filterAAAARule{
+			matcher: m,
+			mode:    r.Mode,
+		})
+	}
+
+	return filter, nil
+}
+
+// modeOf returns the filter mode for a question received on a listener with
+// listenerMode. An empty mode means AAAA records are not filtered
+func (f *filterAAAA) modeOf(question, listenerMode string) string {
+	mode := f.mode
+	if listenerMode != "" {
+		mode = listenerMode
+	}
+
+	for _, r := range f.rules {
+		if r.matcher.Match(question) {
+			mode = r.mode
+			break
+		}
+	}
+
+	if mode == FilterAAAAOff {
+		return ""
+	}
+
+	return mode
+}
+
+// denyAAAA answers an AAAA query with NODATA
+func (s *Server) denyAAAA(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.RecursionAvailable = true
+	setEDE(m, dns.ExtendedErrorCodeFiltered, edeTextAAAAFiltered)
+
+	err := s.writeMsg(w, req, m)
+	if err != nil {
+		s.fwdl.Error(err)
+	}
+}
+
+// stripAAAA returns resp without AAAA records and without ipv6 hints in SVCB/HTTPS records.
+// resp may be shared with other queries, so a new message is returned if anything is removed
+func stripAAAA(resp *dns.Msg) *dns.Msg {
+	if !hasAAAA(resp.Answer) && !hasAAAA(resp.Extra) {
+		return resp
+	}
+
+	m := new(dns.Msg)
+	*m = *resp
+	m.Answer = withoutAAAA(resp.Answer)
+	m.Extra = withoutAAAA(resp.Extra)
+
+	return m
+}
+
+func hasAAAA(rrs []dns.RR) bool {
+	for _, rr := range rrs {
+		switch r := rr.(type) {
+		case *dns.AAAA:
+			return true
+		case *dns.SVCB:
+			if hasIPv6Hint(r) {
+				return true
+			}
+		case *dns.HTTPS:
+			if hasIPv6Hint(&r.SVCB) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasIPv6Hint(r *dns.SVCB) bool {
+	for _, kv := range r.Value {
+		if kv.Key() == dns.SVCB_IPV6HINT {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withoutAAAA(rrs []dns.RR) []dns.RR {
+	filtered := []dns.RR{}
+
+	for _, rr := range rrs {
+		switch r := rr.(type) {
+		case *dns.AAAA:
+			continue
+		case *dns.SVCB:
+			if hasIPv6Hint(r) {
+				c := dns.Copy(r).(*dns.SVCB)
+				c.Value = withoutIPv6Hint(r.Value)
+				rr = c
+			}
+		case *dns.HTTPS:
+			if hasIPv6Hint(&r.SVCB) {
+				c := dns.Copy(r).(*dns.HTTPS)
+				c.Value = withoutIPv6Hint(r.Value)
+				rr = c
+			}
+		}
+		filtered = append(filtered, rr)
+	}
+
+	return filtered
+}
+
+func withoutIPv6Hint(values []dns.SVCBKeyValue) []dns.SVCBKeyValue {
+	filtered := []dns.SVCBKeyValue{}
+	for _, kv := range values {
+		if kv.Key() != dns.SVCB_IPV6HINT {
+			filtered = append(filtered, kv)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+	qc "github.com/ulfox/nettrust/dns/cache"
+)
+
+// flight is an in-flight or completed exchange
+type flight struct {
+	wg   sync.WaitGroup
+	resp *dns.Msg
+	err  error
+	dups int
+}
+
+// flightKey identifies a query in flight. Queries whose answers are authorized are not
+// coalesced with queries whose answers are not, so every answer that has to reach the
+// authorizer does
+type flightKey struct {
+	qc.Key
+	authorize bool
+}
+
+// flightGroup coalesces concurrent identical queries, in the same way as
+// golang.org/x/sync/singleflight. Only the first query for a key is sent upstream,
+// queries for the same key that arrive while it is in flight wait for its answer
+type flightGroup struct {
+	sync.Mutex
+	flights map[flightKey]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[flightKey]*flight),
+	}
+}
+
+// do runs fn once for all concurrent callers with the same key and returns its result
+// to every caller. shared is true if the answer was given to more than one caller, in which
+// case the message must be copied before it is modified
+func (g *flightGroup) do(key flightKey, fn func() (*dns.Msg, error)) (resp *dns.Msg, shared bool, err error) {
+	g.Lock()
+	if f, ok := g.flights[key]; ok {
+		f.dups++
+		g.Unlock()
+		f.wg.Wait()
+		return f.resp, true, f.err
+	}
+
+	f := &flight{}
+	f.wg.Add(1)
+	g.flights[key] = f
+	g.Unlock()
+
+	f.resp, f.err = fn()
+
+	g.Lock()
+	delete(g.flights, key)
+	g.Unlock()
+	f.wg.Done()
+
+	return f.resp, f.dups > 0, f.err
+}
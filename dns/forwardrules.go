@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ulfox/nettrust/dns/domains"
+)
+
+// ForwardRule sends queries for names under Domains (the domain itself and all of its
+// subdomains) to its own upstreams. If PassThrough is true, answers are sent to the
+// client without being authorized in the firewall
+type ForwardRule struct {
+	Domains     []string
+	Upstreams   []Upstream
+	Strategy    string
+	PassThrough bool
+}
+
+type forwardRule struct {
+	matcher     *domains.Matcher
+	forwarder   *forwarder
+	passThrough bool
+}
+
+func newForwardRules(rules []ForwardRule, udpBufferSize uint16, health UpstreamHealth, logger *logrus.Entry) ([]*forwardRule, error) {
+	fwdRules := []*forwardRule{}
+
+	for _, r := range rules {
+		if len(r.Domains) == 0 {
+			return nil, fmt.Errorf(errForwardRule)
+		}
+
+		entries := []string{}
+		for _, d := range r.Domains {
+			d = domains.Normalize(d)
+			entries = append(entries, d, "*."+d)
+		}
+
+		m, err := domains.NewMatcher(entries)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := newForwarder(r.Upstreams, r.Strategy, udpBufferSize, health, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		fwdRules = append(fwdRules, &forwardRule{
+			matcher:     m,
+			forwarder:   f,
+			passThrough: r.PassThrough,
+		})
+	}
+
+	return fwdRules, nil
+}
+
+// route returns the forwarder that should be used for a question and whether the
+// answer should be authorized. Forward rules are checked in order, the first rule
+// that matches is used. If no rule matches, the default forwarder is used
+func (s *Server) route(question string) (*forwarder, bool) {
+	for _, r := range s.forwardRules {
+		if r.matcher.Match(question) {
+			return r.forwarder, !r.passThrough
+		}
+	}
+
+	return s.forwarder, true
+}
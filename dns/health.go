@@ -0,0 +1,276 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CircuitClosed upstream is healthy and receives queries
+	CircuitClosed = "closed"
+	// CircuitOpen upstream has failed too many times in a row and is skipped until its cooldown passes
+	CircuitOpen = "open"
+	// CircuitHalfOpen upstream cooldown has passed and a single trial query decides if the circuit closes again
+	CircuitHalfOpen = "half-open"
+
+	defaultFailThreshold = 3
+	defaultCooldown      = 30
+	defaultProbeName     = "."
+)
+
+// UpstreamHealth configures upstream circuit breaking and active probing. After FailThreshold
+// consecutive failed exchanges the circuit of an upstream opens and the upstream is skipped
+// for Cooldown seconds. Every ProbeInterval seconds all upstreams are probed with an NS query
+// for ProbeName, a successful probe closes the circuit. ProbeInterval <= 0 disables probes
+type UpstreamHealth struct {
+	ProbeInterval int
+	FailThreshold int
+	Cooldown      int
+	ProbeName     string
+}
+
+func (h UpstreamHealth) withDefaults() UpstreamHealth {
+	if h.FailThreshold <= 0 {
+		h.FailThreshold = defaultFailThreshold
+	}
+
+	if h.Cooldown <= 0 {
+		h.Cooldown = defaultCooldown
+	}
+
+	if h.ProbeName == "" {
+		h.ProbeName = defaultProbeName
+	}
+	h.ProbeName = dns.Fqdn(h.ProbeName)
+
+	return h
+}
+
+// UpstreamStatus describes the health of an upstream at a point in time
+type UpstreamStatus struct {
+	Addr      string
+	Proto     string
+	State     string
+	Failures  int
+	RTT       time.Duration
+	LastError string
+	Since     time.Time
+}
+
+// circuit is the circuit breaker of an upstream
+type circuit struct {
+	state    string
+	failures int
+	since    time.Time
+	trial    bool
+	lastErr  error
+}
+
+// available reports if the upstream should be asked. An open circuit turns half-open
+// once its cooldown has passed, a half-open circuit lets a single trial query through
+func (u *upstream) available() bool {
+	u.Lock()
+	defer u.Unlock()
+
+	switch u.circuit.state {
+	case CircuitOpen:
+		if time.Since(u.circuit.since) < time.Duration(u.health.Cooldown)*time.Second {
+			return false
+		}
+		u.setState(CircuitHalfOpen)
+		u.circuit.trial = true
+		return true
+	case CircuitHalfOpen:
+		if u.circuit.trial {
+			return false
+		}
+		u.circuit.trial = true
+		return true
+	}
+
+	return true
+}
+
+// success records a successful exchange and closes the circuit
+func (u *upstream) success(rtt time.Duration) {
+	u.Lock()
+	defer u.Unlock()
+
+	u.addRTT(rtt)
+
+	u.circuit.failures = 0
+	u.circuit.trial = false
+	if u.circuit.state != CircuitClosed {
+		u.setState(CircuitClosed)
+	}
+}
+
+// failure records a failed exchange. The circuit opens after FailThreshold
+// consecutive failures, or immediately if a half-open trial fails. The failure is
+// charged to the rtt as a round trip of the upstream's timeout, so that failing
+// upstreams are asked last by the lowest-latency strategy
+func (u *upstream) failure(err error) {
+	u.Lock()
+	defer u.Unlock()
+
+	u.addRTT(time.Duration(u.Timeout) * time.Millisecond)
+
+	u.circuit.failures++
+	u.circuit.lastErr = err
+	u.circuit.trial = false
+
+	switch u.circuit.state {
+	case CircuitHalfOpen:
+		u.setState(CircuitOpen)
+	case CircuitClosed:
+		if u.circuit.failures >= u.health.FailThreshold {
+			u.setState(CircuitOpen)
+		}
+	}
+}
+
+// addRTT adds a round trip time to the moving average of the upstream. u must be locked
+func (u *upstream) addRTT(rtt time.Duration) {
+	if u.rtt == 0 {
+		u.rtt = rtt
+		return
+	}
+	u.rtt = (u.rtt*7 + rtt) / 8
+}
+
+// setState changes the circuit state and logs the transition. u must be locked
+func (u *upstream) setState(state string) {
+	prev := u.circuit.state
+	u.circuit.state = state
+	u.circuit.since = time.Now()
+
+	if state == CircuitOpen {
+		u.logger.Warnf(warnCircuitState, u.Addr, prev, state, u.circuit.failures, u.circuit.lastErr)
+		return
+	}
+	u.logger.Infof(infoCircuitState, u.Addr, prev, state)
+}
+
+func (u *upstream) status() UpstreamStatus {
+	u.Lock()
+	defer u.Unlock()
+
+	st := UpstreamStatus{
+		Addr:     u.Addr,
+		Proto:    u.Proto,
+		State:    u.circuit.state,
+		Failures: u.circuit.failures,
+		RTT:      u.rtt,
+		Since:    u.circuit.since,
+	}
+
+	if u.circuit.lastErr != nil {
+		st.LastError = u.circuit.lastErr.Error()
+	}
+
+	return st
+}
+
+// probe sends an NS query for the probe name directly to the upstream, bypassing
+// retries and the circuit, and records the result
+func (u *upstream) probe() {
+	m := new(dns.Msg)
+	m.SetQuestion(u.health.ProbeName, dns.TypeNS)
+	m.RecursionDesired = true
+
+	_, rtt, err := u.client.Exchange(m, u.Addr)
+	if err != nil {
+		u.failure(err)
+		return
+	}
+	u.success(rtt)
+}
+
+// upstreams returns all upstreams of the default forwarder and the forward rules
+func (s *Server) upstreams() []*upstream {
+	upstreams := append([]*upstream{}, s.forwarder.upstreams...)
+	for _, r := range s.forwardRules {
+		upstreams = append(upstreams, r.forwarder.upstreams...)
+	}
+
+	return upstreams
+}
+
+// UpstreamStatus returns the health of all upstreams, including the upstreams of forward rules
+func (s *Server) UpstreamStatus() []UpstreamStatus {
+	status := []UpstreamStatus{}
+	for _, u := range s.upstreams() {
+		status = append(status, u.status())
+	}
+
+	return status
+}
+
+// LogUpstreamStatus logs the health of all upstreams, including the upstreams of forward rules
+func (s *Server) LogUpstreamStatus() {
+	l := s.logger.WithFields(logrus.Fields{
+		"Component": "DNS Upstreams",
+		"Stage":     "Status",
+	})
+
+	for _, st := range s.UpstreamStatus() {
+		l.Infof(infoUpstreamStatus, st.Addr, st.State, st.RTT, st.Failures)
+	}
+}
+
+// HealthBackground spawns a goroutine that probes all upstreams every probe interval.
+// If probes are disabled, the goroutine only waits to be expired
+func (s *Server) HealthBackground() *ServiceContext {
+	healthContext := &ServiceContext{}
+
+	var serviceWG sync.WaitGroup
+	healthContext.wg = &serviceWG
+
+	ctx, cancel := context.WithCancel(context.Background())
+	healthContext.cancel = cancel
+
+	l := s.logger.WithFields(logrus.Fields{
+		"Component": "DNS Upstreams",
+		"Stage":     "Health",
+	})
+
+	serviceWG.Add(1)
+	go func(ctx context.Context, wg *sync.WaitGroup, l *logrus.Entry) {
+		var probe <-chan time.Time
+		if s.health.ProbeInterval > 0 {
+			l.Infof("Probing upstreams every %d seconds", s.health.ProbeInterval)
+			ticker := time.NewTicker(time.Duration(s.health.ProbeInterval) * time.Second)
+			defer ticker.Stop()
+			probe = ticker.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				l.WithField("Stage", "Term").Info("Bye!")
+				wg.Done()
+				return
+			case <-probe:
+				var probes sync.WaitGroup
+				for _, u := range s.upstreams() {
+					probes.Add(1)
+					go func(u *upstream) {
+						u.probe()
+						probes.Done()
+					}(u)
+				}
+				probes.Wait()
+
+				for _, st := range s.UpstreamStatus() {
+					l.Debugf(infoUpstreamStatus, st.Addr, st.State, st.RTT, st.Failures)
+				}
+			}
+		}
+	}(ctx, &serviceWG, l)
+
+	return healthContext
+}
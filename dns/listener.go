@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ListenerUDP serves plaintext dns over udp
+	ListenerUDP = "udp"
+	// ListenerTCP serves plaintext dns over tcp
+	ListenerTCP = "tcp"
+	// ListenerDoT serves DNS-over-TLS
+	ListenerDoT = "dot"
+	// ListenerDoH serves DNS-over-HTTPS on /dns-query
+	ListenerDoH = "doh"
+)
+
+// Listener describes a NetTrust listen endpoint. Proto can be udp, tcp, dot or doh.
+// Cert and CertKey are required by dot and doh listeners. ClientCaCert and ClientAuth
+// (none, request, require, verify-if-given, require-and-verify) configure client
+// certificate authentication for dot and doh listeners. FilterAAAA overrides the
+// default filter AAAA mode for queries received on the listener, see FilterAAAA
+type Listener struct {
+	Addr, Proto, Cert, CertKey string
+	ClientCaCert, ClientAuth   string
+	FilterAAAA                 string
+}
+
+// listener is a validated Listener along with its certificate, client CA and tls config
+type listener struct {
+	Listener
+	cert      *certFile
+	clientCA  *certFile
+	tlsConfig *tls.Config
+}
+
+func newListeners(listeners []Listener, logger *logrus.Entry) ([]*listener, error) {
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf(errListenAddr)
+	}
+
+	lns := []*listener{}
+	for _, l := range listeners {
+		if l.Addr == "" {
+			return nil, fmt.Errorf(errListenAddr)
+		}
+
+		if _, _, err := net.SplitHostPort(l.Addr); err != nil {
+			return nil, err
+		}
+
+		ln := &listener{Listener: l}
+
+		switch l.Proto {
+		case ListenerUDP, ListenerTCP:
+		case ListenerDoT, ListenerDoH:
+			cert, err := newKeyPair(l.Cert, l.CertKey)
+			if err != nil {
+				return nil, err
+			}
+			ln.cert = cert
+
+			ln.tlsConfig, err = listenerTLSConfig(ln, logger)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf(errListenProto, l.Proto, l.Addr)
+		}
+
+		lns = append(lns, ln)
+	}
+
+	return lns, nil
+}
@@ -2,8 +2,10 @@ package dns
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 type ServiceContext struct {
 	cancel context.CancelFunc
 	wg     *sync.WaitGroup
+	err    chan error
 }
 
 // Expire will call cancel to terminate a context immediately, causing the goroutine to exit
@@ -27,6 +30,20 @@ func (f *ServiceContext) Wait() {
 	f.wg.Wait()
 }
 
+// Err returns a channel that receives the error that made a service stop on its own,
+// for example a listener that could not bind its address. The channel is closed when
+// the service stops. Services that can not fail return a nil channel
+func (f *ServiceContext) Err() <-chan error {
+	return f.err
+}
+
+// Close stops the DNS TTL cache manager, which saves the cache to the cache file if one
+// is set. It should be called once, after all listeners have stopped
+func (s *Server) Close() {
+	s.cacheContext.Expire()
+	s.cacheContext.Wait()
+}
+
 // dnsTTLCacheManager spawns a goroutine for checking cache for expired queries
 func (s *Server) dnsTTLCacheManager() (*ServiceContext, error) {
 	if s.cache == nil {
@@ -53,6 +70,14 @@ func (s *Server) dnsTTLCacheManager() (*ServiceContext, error) {
 			"Stage":     "Cache Watcher",
 		})
 		ticker := time.NewTicker(30 * time.Second)
+
+		var save <-chan time.Time
+		if s.cacheFile != "" && s.cacheSave > 0 {
+			saveTicker := time.NewTicker(time.Duration(s.cacheSave) * time.Second)
+			defer saveTicker.Stop()
+			save = saveTicker.C
+		}
+
 		for {
 			select {
 			case <-ctx.Done():
@@ -61,6 +86,10 @@ func (s *Server) dnsTTLCacheManager() (*ServiceContext, error) {
 					"Stage":     "Term",
 				})
 
+				if s.cacheFile != "" {
+					s.saveCache(l)
+				}
+
 				l.Info("Bye!")
 				wg.Done()
 				return
@@ -69,18 +98,11 @@ func (s *Server) dnsTTLCacheManager() (*ServiceContext, error) {
 					break
 				}
 				l.Debug("Checking DNS Cache")
-				for _, h := range s.cache.ExpiredQueries() {
-					l.Debugf("Deleting host [%s] from cache", h)
-					s.cache.Delete(h)
-				}
-				l.Debugf("Freeing up DNS Cache memory")
-				s.cache.NewResolved()
-				for _, h := range s.cache.ExpiredMXQueries() {
-					l.Debugf("Deleting host [%s] from NX cache", h)
-					s.cache.DeleteNX(h)
-				}
-				l.Debugf("Freeing up DNS NX Cache memory")
-				s.cache.NewNX()
+				deleted := s.cache.Expire()
+				entries, bytes := s.cache.Len()
+				l.Debugf("Deleted %d expired answers from cache, %d answers (%d bytes) remain", deleted, entries, bytes)
+			case <-save:
+				s.saveCache(l)
 			default:
 				time.Sleep(time.Millisecond * 50)
 			}
@@ -90,14 +112,90 @@ func (s *Server) dnsTTLCacheManager() (*ServiceContext, error) {
 	return dnsCacheContext, nil
 }
 
-// UDPListenBackground for spawning a udp DNS Server
-func (s *Server) UDPListenBackground(fn func(resp *dns.Msg) error) *ServiceContext {
+// saveCache writes a snapshot of the dns cache to cacheFile
+func (s *Server) saveCache(l *logrus.Entry) {
+	n, err := s.cache.Save(s.cacheFile)
+	if err != nil {
+		l.Errorf(errCacheSave, s.cacheFile, err)
+		return
+	}
+
+	l.Debugf(infoCacheSaved, n, s.cacheFile)
+}
+
+// ListenBackground for spawning a DNS Server for every configured listener. fn is called with
+// every answer that should be authorized and the client that sent the query
+func (s *Server) ListenBackground(fn func(resp *dns.Msg, client net.IP) error) []*ServiceContext {
+	contexts := []*ServiceContext{}
+
+	for _, l := range s.listeners {
+		if l.Proto == ListenerDoH {
+			contexts = append(contexts, s.dohListenBackground(l, fn))
+			continue
+		}
+		contexts = append(contexts, s.dnsListenBackground(l, fn))
+	}
+
+	return contexts
+}
+
+// UDPListenBackground for spawning a udp DNS Server on the first udp listener
+func (s *Server) UDPListenBackground(fn func(resp *dns.Msg, client net.IP) error) *ServiceContext {
+	return s.protoListenBackground(fn, ListenerUDP)
+}
+
+// TCPListenBackground for spawning a tcp DNS Server on the first tcp or DoT listener
+func (s *Server) TCPListenBackground(fn func(resp *dns.Msg, client net.IP) error) *ServiceContext {
+	return s.protoListenBackground(fn, ListenerTCP, ListenerDoT)
+}
+
+// DoHListenBackground for spawning a DNS-over-HTTPS (RFC 8484) Server on the first doh listener.
+// Queries are served on /dns-query
+func (s *Server) DoHListenBackground(fn func(resp *dns.Msg, client net.IP) error) *ServiceContext {
+	return s.protoListenBackground(fn, ListenerDoH)
+}
+
+// protoListenBackground spawns a DNS Server on the first listener with one of protos. If there is
+// no such listener, the returned context reports it on Err
+func (s *Server) protoListenBackground(fn func(resp *dns.Msg, client net.IP) error, protos ...string) *ServiceContext {
+	for _, l := range s.listeners {
+		for _, p := range protos {
+			if l.Proto != p {
+				continue
+			}
+			if l.Proto == ListenerDoH {
+				return s.dohListenBackground(l, fn)
+			}
+			return s.dnsListenBackground(l, fn)
+		}
+	}
+
+	dnsServerContext := &ServiceContext{
+		cancel: func() {},
+		wg:     &sync.WaitGroup{},
+		err:    make(chan error, 1),
+	}
+	dnsServerContext.err <- fmt.Errorf(errNoListener, strings.Join(protos, "/"))
+	close(dnsServerContext.err)
+
+	return dnsServerContext
+}
+
+// dnsListenBackground for spawning a udp, tcp or DoT DNS Server
+func (s *Server) dnsListenBackground(ln *listener, fn func(resp *dns.Msg, client net.IP) error) *ServiceContext {
+	component := "[UDP] DNSServer"
+	if ln.Proto == ListenerTCP {
+		component = "[TCP] DNSServer"
+	} else if ln.Proto == ListenerDoT {
+		component = "[TLS] DNSServer"
+	}
+
 	s.logger.WithFields(logrus.Fields{
 		"Component": "DNS Server",
 		"Stage":     "Init",
-	}).Info("Starting UDP DNS Server")
+	}).Infof("Starting %s DNS Server on %s", strings.ToUpper(ln.Proto), ln.Addr)
 
-	dnsServerContext := &ServiceContext{}
+	dnsServerContext := &ServiceContext{err: make(chan error, 1)}
 
 	var serviceListenerWG sync.WaitGroup
 	dnsServerContext.wg = &serviceListenerWG
@@ -105,67 +203,77 @@ func (s *Server) UDPListenBackground(fn func(resp *dns.Msg) error) *ServiceConte
 	ctxListener, cancelListener := context.WithCancel(context.Background())
 	dnsServerContext.cancel = cancelListener
 
-	s.udpServer = &dns.Server{
-		//UDPSize: 4096,
-		Addr: s.listenAddr, Net: "udp",
+	srv := &dns.Server{
+		Addr: ln.Addr, Net: ln.Proto,
 		Handler: dns.HandlerFunc(
 			func(w dns.ResponseWriter, r *dns.Msg) {
-				s.fwd(w, r, fn)
+				if !s.allowClient(w, r) || !s.limitClient(w, r) {
+					return
+				}
+				s.fwd(w, r, ln, fn)
 			},
 		),
 	}
 
+	if ln.Proto == ListenerUDP {
+		srv.UDPSize = int(s.udpSize)
+	}
+
+	if ln.Proto == ListenerDoT {
+		srv.Net = "tcp-tls"
+		srv.TLSConfig = ln.tlsConfig
+	}
+
 	serviceListenerWG.Add(1)
-	go func(wg *sync.WaitGroup, srv *dns.Server) {
+	go func(wg *sync.WaitGroup, srv *dns.Server, errs chan<- error) {
 		l := s.logger.WithFields(logrus.Fields{
-			"Component": "[UDP] DNSServer",
+			"Component": component,
 			"Stage":     "Init",
 		})
 
 		l.Info("Starting")
 		if err := srv.ListenAndServe(); err != nil {
 			l.Error(err)
+			errs <- fmt.Errorf(errListenerFailed, ln.Proto, ln.Addr, err)
 		}
+		close(errs)
 		wg.Done()
-	}(&serviceListenerWG, s.udpServer)
+	}(&serviceListenerWG, srv, dnsServerContext.err)
 
 	serviceListenerWG.Add(1)
-	go func(ctx, ctxOnErr context.Context, wg *sync.WaitGroup, srv *dns.Server) {
+	go func(ctx context.Context, wg *sync.WaitGroup, srv *dns.Server) {
 		l := s.logger.WithFields(logrus.Fields{
-			"Component": "[UDP] DNSServer",
+			"Component": component,
 			"Stage":     "Term",
 		})
 
 		for {
 			select {
 			case <-ctx.Done():
+				// Shutdown fails if the server has already stopped on its own
 				if err := srv.Shutdown(); err != nil {
-					l.Fatal(err)
+					l.Debug(err)
 				}
-				s.cacheContext.Expire()
-				s.cacheContext.Wait()
 				l.Info("Bye!")
 				wg.Done()
 				return
-			case <-ctxOnErr.Done():
-				s.killOnErr()
 			default:
 				time.Sleep(time.Millisecond * 50)
 			}
 		}
-	}(ctxListener, s.ctxOnErr, &serviceListenerWG, s.udpServer)
+	}(ctxListener, &serviceListenerWG, srv)
 
 	return dnsServerContext
 }
 
-// TCPListenBackground for spawning a tcp DNS Server
-func (s *Server) TCPListenBackground(fn func(resp *dns.Msg) error) *ServiceContext {
+// dohListenBackground for spawning a DNS-over-HTTPS (RFC 8484) Server. Queries are served on /dns-query
+func (s *Server) dohListenBackground(ln *listener, fn func(resp *dns.Msg, client net.IP) error) *ServiceContext {
 	s.logger.WithFields(logrus.Fields{
 		"Component": "DNS Server",
 		"Stage":     "Init",
-	}).Info("Starting TCP DNS Server")
+	}).Infof("Starting DoH DNS Server on %s", ln.Addr)
 
-	dnsServerContext := &ServiceContext{}
+	dnsServerContext := &ServiceContext{err: make(chan error, 1)}
 
 	var serviceListenerWG sync.WaitGroup
 	dnsServerContext.wg = &serviceListenerWG
@@ -173,67 +281,49 @@ func (s *Server) TCPListenBackground(fn func(resp *dns.Msg) error) *ServiceConte
 	ctxListener, cancelListener := context.WithCancel(context.Background())
 	dnsServerContext.cancel = cancelListener
 
-	s.tcpServer = &dns.Server{
-		Addr: s.listenAddr, Net: "tcp",
-		Handler: dns.HandlerFunc(
-			func(w dns.ResponseWriter, r *dns.Msg) {
-				s.fwd(w, r, fn)
-			},
-		),
+	srv := &http.Server{
+		Addr:      ln.Addr,
+		Handler:   s.dohHandler(ln, fn),
+		TLSConfig: ln.tlsConfig,
 	}
 
 	serviceListenerWG.Add(1)
-	go func(wg *sync.WaitGroup, srv *dns.Server) {
+	go func(wg *sync.WaitGroup, srv *http.Server, errs chan<- error) {
 		l := s.logger.WithFields(logrus.Fields{
-			"Component": "[TCP] DNSServer",
+			"Component": "[DoH] DNSServer",
 			"Stage":     "Init",
 		})
 
-		if s.listenTLS {
-			l = s.logger.WithFields(logrus.Fields{
-				"Component": "[TLS] DNSServer",
-				"Stage":     "Init",
-			})
-
-			srv.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{*s.listenCerts},
-			}
-
-			srv.Net = "tcp-tls"
-		}
-
-		l.Info("Starging")
-
-		if err := srv.ListenAndServe(); err != nil {
+		l.Info("Starting")
+		if err := srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 			l.Error(err)
+			errs <- fmt.Errorf(errListenerFailed, ln.Proto, ln.Addr, err)
 		}
+		close(errs)
 		wg.Done()
-	}(&serviceListenerWG, s.tcpServer)
+	}(&serviceListenerWG, srv, dnsServerContext.err)
 
 	serviceListenerWG.Add(1)
-	go func(ctx, ctxOnErr context.Context, wg *sync.WaitGroup, srv *dns.Server) {
+	go func(ctx context.Context, wg *sync.WaitGroup, srv *http.Server) {
 		l := s.logger.WithFields(logrus.Fields{
-			"Component": "[TCP] DNSServer",
+			"Component": "[DoH] DNSServer",
 			"Stage":     "Term",
 		})
+
 		for {
 			select {
 			case <-ctx.Done():
-				if err := srv.Shutdown(); err != nil {
-					l.Fatal(err)
+				if err := srv.Shutdown(context.Background()); err != nil {
+					l.Error(err)
 				}
-				s.cacheContext.Expire()
-				s.cacheContext.Wait()
 				l.Info("Bye!")
 				wg.Done()
 				return
-			case <-ctxOnErr.Done():
-				s.killOnErr()
 			default:
 				time.Sleep(time.Millisecond * 50)
 			}
 		}
-	}(ctxListener, s.ctxOnErr, &serviceListenerWG, s.tcpServer)
+	}(ctxListener, &serviceListenerWG, srv)
 
 	return dnsServerContext
 }
@@ -2,18 +2,23 @@ package dns
 
 import (
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/miekg/dns"
+	qc "github.com/ulfox/nettrust/dns/cache"
+	"github.com/ulfox/nettrust/dns/domains"
 )
 
-func (s *Server) fwd(w dns.ResponseWriter, req *dns.Msg, fn func(resp *dns.Msg) error) {
+func (s *Server) fwd(w dns.ResponseWriter, req *dns.Msg, ln *listener, fn func(resp *dns.Msg, client net.IP) error) {
 	if len(req.Question) == 0 {
+		s.strike(w, StrikeMalformed)
 		s.qErr(w, req, fmt.Errorf(errQuery))
 		return
 	}
 
 	if len(req.Question) > 1 {
+		s.strike(w, StrikeMalformed)
 		dns.HandleFailed(w, req)
 		questions := []string{}
 
@@ -27,157 +32,335 @@ func (s *Server) fwd(w dns.ResponseWriter, req *dns.Msg, fn func(resp *dns.Msg)
 
 	question := s.cache.Question(req)
 
-	if s.checkDomainBlacklist(question) {
-		dns.HandleFailed(w, req)
-		s.fwdl.Infof(infoDomainBlacklist, question)
+	if id := clientIdentity(w); id != "" {
+		s.fwdl.Debugf(infoClientIdentity, question, w.RemoteAddr(), id)
+	}
+
+	if s.blacklisted(w, req, question) {
 		return
 	}
 
-	var resp *dns.Msg
-	var err error
+	if resp, ok := s.zones.Lookup(req); ok {
+		s.answerLocal(w, req, resp, ln, fn)
+		return
+	}
 
-	if s.cache.GetTTL() <= 0 {
-		goto forwardUpstream
+	if s.notWhitelisted(w, req, question) {
+		return
 	}
 
-	// We need to handle IPv6 cache differently
-	// Maybe a new cache or appening a string to
-	// keep IPv6 records apart from IPv4
-	if req.Question[0].Qtype == dns.TypeAAAA {
-		goto forwardUpstream
+	filterMode := s.filterAAAA.modeOf(question, ln.FilterAAAA)
+	if s.filteredAAAA(w, req, question, filterMode) {
+		return
 	}
 
-	if isCached := s.cache.Exists(question); isCached {
-		if hasExpired := s.cache.HasExpired(question); hasExpired {
-			s.cache.Delete(question)
-			s.fwdl.Debugf(infoCacheObjExpired, question)
-			goto forwardUpstream
-		}
+	forwarder, authorize := s.route(question)
+	key := qc.KeyOf(req)
 
-		r := s.cache.Get(question)
-		if r != nil {
-			s.fwdl.Debugf(infoCacheObjFound, question)
-			resp = r
-			resp.Id = req.Id
-			goto tellClient
-		}
+	client := clientIP(w)
+	if authorize && !s.clients.authorizes(client) {
+		s.fwdl.Debugf(infoClientNoAuthorize, question, w.RemoteAddr())
+		authorize = false
+	}
 
-		s.fwdl.Errorf(errCacheFetch, question)
+	var resp *dns.Msg
+	var shared, resolved bool
+	var err error
+
+	if s.cache.GetTTL() <= 0 {
+		goto forwardUpstream
 	}
 
-	if isNXCached := s.cache.ExistsNX(question); isNXCached {
-		if hasExpired := s.cache.HasExpiredNX(question); !hasExpired {
-			s.fwdl.Debugf(infoCacheObjFoundNil, question)
-			resp = req
-			goto tellClient
+	if r, prefetch := s.cache.Get(key); r != nil {
+		s.fwdl.Debugf(infoCacheObjFound, question)
+		if prefetch {
+			go s.prefetch(forwarder, req.Copy())
 		}
-
-		s.cache.DeleteNX(question)
-		s.fwdl.Debugf(infoCacheObjExpired, question)
+		resp = r
+		resp.Id = req.Id
+		resp.Question = req.Question
+		goto tellClient
 	}
 
 forwardUpstream:
-	resp, _, err = s.client.Exchange(req, s.fwdAddr)
+	// Identical queries that arrive while this one is in flight share its
+	// upstream exchange and firewall authorization
+	resp, shared, err = s.inflight.do(flightKey{key, authorize}, func() (*dns.Msg, error) {
+		resolved = true
+		return s.resolve(req, key, forwarder, authorize, client, fn)
+	})
 	if err != nil {
 		s.qErr(w, req, err)
 		return
 	}
 
-	if s.cache.GetTTL() > 0 {
-		err = s.pushToCache(resp)
-		if err != nil {
-			s.fwdl.Error(err)
-		}
+	if shared {
+		s.fwdl.Debugf(infoQueryCoalesced, question)
+		resp = resp.Copy()
 	}
+	resp.Id = req.Id
+	resp.Question = req.Question
+
+	// A shared answer was authorized once by the query that resolved it, for the
+	// other queries only the client is recorded
+	if !resolved {
+		s.recordClient(resp, authorize, client)
+	}
+	goto writeResp
 
 tellClient:
-	err = fn(resp)
+	err = s.tellAuthorizer(question, resp, authorize, client, fn)
 	if err != nil {
 		s.qErr(w, req, err)
 		return
 	}
 
-	err = w.WriteMsg(resp)
+writeResp:
+	if resp.Rcode == dns.RcodeNameError {
+		s.strike(w, StrikeNXDomain)
+	}
+
+	if filterMode != "" {
+		resp = stripAAAA(resp)
+	}
+
+	err = s.writeMsg(w, req, resp)
 	if err != nil {
 		s.qErr(w, req, err)
 	}
 }
 
-func (s *Server) qErr(w dns.ResponseWriter, req *dns.Msg, err error) {
-	s.fwdl.Error(err)
-	s.cache.RegisterNX(s.cache.Question(req))
-	dns.HandleFailed(w, req)
+// blacklisted answers req based on the blocked response and counts a strike if question
+// is blacklisted. Returns true if req was answered
+func (s *Server) blacklisted(w dns.ResponseWriter, req *dns.Msg, question string) bool {
+	if !s.checkDomainBlacklist(question) {
+		return false
+	}
+
+	s.denyBlacklisted(w, req)
+	s.fwdl.Infof(infoDomainBlacklist, question)
+	s.strike(w, StrikeBlocked)
+
+	return true
 }
 
-func (s *Server) pushToCache(msg *dns.Msg) error {
-	// Do not register IPv6 (see commend at line ~43 for additional info)
-	if msg.Question[0].Qtype == dns.TypeAAAA {
-		return nil
+// notWhitelisted answers req based on the whitelist mode and counts a strike if question
+// is not whitelisted. Returns true if req was answered
+func (s *Server) notWhitelisted(w dns.ResponseWriter, req *dns.Msg, question string) bool {
+	if s.whitelistMode == "" || s.domainWhitelist.Match(question) {
+		return false
 	}
 
-	if len(msg.Answer) == 0 {
-		return s.registerNX(msg)
+	s.denyNotWhitelisted(w, req)
+	s.fwdl.Infof(infoNotWhitelisted, question)
+	s.strike(w, StrikeBlocked)
+
+	return true
+}
+
+// filteredAAAA answers req with NODATA if it is an AAAA query and filterMode is nodata.
+// Returns true if req was answered
+func (s *Server) filteredAAAA(w dns.ResponseWriter, req *dns.Msg, question, filterMode string) bool {
+	if filterMode != FilterAAAANoData || req.Question[0].Qtype != dns.TypeAAAA {
+		return false
 	}
 
-	if len(msg.Answer) == 1 {
-		if q4, ok := msg.Answer[0].(*dns.A); ok {
-			if q4.A.String() == "0.0.0.0" {
-				return s.registerNX(msg)
-			}
-		}
+	s.denyAAAA(w, req)
+	s.fwdl.Debugf(infoAAAAFiltered, question)
 
-		if q6, ok := msg.Answer[0].(*dns.AAAA); ok {
-			if q6.AAAA.String() == "::" {
-				return s.registerNX(msg)
-			}
-		}
+	return true
+}
+
+// resolve asks upstream for req, caches the answer and passes it to the authorizer
+func (s *Server) resolve(req *dns.Msg, key qc.Key, forwarder *forwarder, authorize bool, client net.IP, fn func(resp *dns.Msg, client net.IP) error) (*dns.Msg, error) {
+	resp, err := s.exchange(req, key, forwarder)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.register(msg)
+	err = s.tellAuthorizer(s.cache.Question(req), resp, authorize, client, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
 }
 
-func (s *Server) registerNX(msg *dns.Msg) error {
-	q := s.cache.Question(msg)
+// exchange asks upstream for req and caches the answer. If upstream fails or answers
+// SERVFAIL, a stale answer from cache is used when available
+func (s *Server) exchange(req *dns.Msg, key qc.Key, forwarder *forwarder) (*dns.Msg, error) {
+	question := s.cache.Question(req)
 
-	if exists := s.cache.ExistsNX(q); exists {
-		if expired := s.cache.HasExpiredNX(q); expired {
-			ok := s.cache.RenewNX(q)
-			if !ok {
-				return fmt.Errorf(errCacheCoulndNotRenew, q)
-			}
+	resp, err := forwarder.exchange(req)
+	if err != nil {
+		resp = s.cache.GetStale(key)
+		if resp == nil {
+			return nil, err
 		}
+		s.fwdl.Warnf(warnServeStale, question, err)
+	} else if resp.Rcode == dns.RcodeServerFailure {
+		if stale := s.cache.GetStale(key); stale != nil {
+			s.fwdl.Warnf(warnServeStale, question, dns.RcodeToString[resp.Rcode])
+			resp = stale
+		}
+	} else if s.cache.GetTTL() > 0 && !s.cache.Set(resp) {
+		s.fwdl.Debugf(infoCacheAnswerSkipped, question)
+	}
+
+	return resp, nil
+}
+
+// tellAuthorizer passes resp to the authorizer, unless the query was routed
+// to a pass through forward rule or the client can not authorize
+func (s *Server) tellAuthorizer(question string, resp *dns.Msg, authorize bool, client net.IP, fn func(resp *dns.Msg, client net.IP) error) error {
+	if !authorize {
+		s.fwdl.Debugf(infoPassThrough, question)
 		return nil
 	}
 
-	ok := s.cache.RegisterNX(q)
-	if !ok {
-		return fmt.Errorf(errCacheRegister, q)
+	return fn(resp, client)
+}
+
+// SetRecordHandler sets a function that records the client of a query whose answer was
+// shared with another query that passed it to the authorizer
+func (s *Server) SetRecordHandler(fn func(resp *dns.Msg, client net.IP)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.recorder = fn
+}
+
+// recordClient passes resp to the record handler, unless the query was routed
+// to a pass through forward rule or the client can not authorize
+func (s *Server) recordClient(resp *dns.Msg, authorize bool, client net.IP) {
+	s.Lock()
+	recorder := s.recorder
+	s.Unlock()
+
+	if authorize && recorder != nil {
+		recorder(resp, client)
 	}
-	return nil
 }
 
-func (s *Server) register(msg *dns.Msg) error {
-	q := s.cache.Question(msg)
+func (s *Server) qErr(w dns.ResponseWriter, req *dns.Msg, err error) {
+	s.fwdl.Error(err)
+	dns.HandleFailed(w, req)
+}
+
+// prefetch refreshes a popular cached answer before it expires, so that clients
+// keep being answered from cache. If the prefetch fails, the answer can be prefetched again
+func (s *Server) prefetch(forwarder *forwarder, req *dns.Msg) {
+	question := s.cache.Question(req)
+	key := qc.KeyOf(req)
 
-	if exists := s.cache.Exists(q); exists {
-		if expired := s.cache.HasExpired(q); expired {
-			ok := s.cache.Renew(q, msg)
-			if !ok {
-				return fmt.Errorf(errCacheCoulndNotRenew, q)
+	resp, err := forwarder.exchange(req)
+	if err != nil {
+		s.cache.PrefetchDone(key)
+		s.fwdl.Warnf(warnCachePrefetch, question, err)
+		return
+	}
+
+	if resp.Rcode == dns.RcodeServerFailure {
+		s.cache.PrefetchDone(key)
+		s.fwdl.Warnf(warnCachePrefetch, question, dns.RcodeToString[resp.Rcode])
+		return
+	}
+
+	if !s.cache.Set(resp) {
+		s.cache.PrefetchDone(key)
+		return
+	}
+	s.fwdl.Debugf(infoCachePrefetched, question)
+}
+
+// answerLocal sends a locally answered query to the client. The answer is passed
+// to the authorizer first if zonesAuthorize is enabled and the client can authorize.
+// A CNAME chain that leads outside of the local zones is resolved like any other query:
+// the target is checked against the domain lists and the filter AAAA mode of ln, answered
+// from cache or upstream, and the answer is authorized based on the target's route
+func (s *Server) answerLocal(w dns.ResponseWriter, req, resp *dns.Msg, ln *listener, fn func(resp *dns.Msg, client net.IP) error) {
+	s.fwdl.Infof(infoZoneAnswer, req.Question[0].Name)
+
+	authorize := s.zonesAuthorize
+	filterMode := ""
+
+	if target := s.zones.Unresolved(resp); target != "" {
+		m := new(dns.Msg)
+		m.SetQuestion(target, req.Question[0].Qtype)
+		m.RecursionDesired = true
+
+		question := s.cache.Question(m)
+		if s.blacklisted(w, req, question) || s.notWhitelisted(w, req, question) {
+			return
+		}
+
+		filterMode = s.filterAAAA.modeOf(question, ln.FilterAAAA)
+		if s.filteredAAAA(w, req, question, filterMode) {
+			return
+		}
+
+		var forwarder *forwarder
+		forwarder, authorize = s.route(question)
+		key := qc.KeyOf(m)
+
+		var r *dns.Msg
+		if s.cache.GetTTL() > 0 {
+			var prefetch bool
+			r, prefetch = s.cache.Get(key)
+			if prefetch {
+				go s.prefetch(forwarder, m.Copy())
 			}
 		}
-		return nil
+
+		if r == nil {
+			var err error
+			r, err = s.exchange(m, key, forwarder)
+			if err != nil {
+				s.qErr(w, req, err)
+				return
+			}
+		}
+		resp.Answer = append(resp.Answer, r.Answer...)
+		resp.Rcode = r.Rcode
+		resp.Authoritative = false
 	}
 
-	ok := s.cache.Register(q, msg)
-	if !ok {
-		return fmt.Errorf(errCacheRegister, q)
+	if client := clientIP(w); authorize && s.clients.authorizes(client) {
+		err := fn(resp, client)
+		if err != nil {
+			s.qErr(w, req, err)
+			return
+		}
 	}
 
-	return nil
+	if filterMode != "" {
+		resp = stripAAAA(resp)
+	}
+
+	err := s.writeMsg(w, req, resp)
+	if err != nil {
+		s.qErr(w, req, err)
+	}
+}
+
+// denyNotWhitelisted answers a query for a domain that is not whitelisted
+// without asking upstream, based on the whitelist mode
+func (s *Server) denyNotWhitelisted(w dns.ResponseWriter, req *dns.Msg) {
+	rcode := dns.RcodeNameError
+	if s.whitelistMode == WhitelistModeRefused {
+		rcode = dns.RcodeRefused
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(req, rcode)
+	setEDE(m, dns.ExtendedErrorCodeFiltered, edeTextNotWhitelisted)
+
+	err := s.writeMsg(w, req, m)
+	if err != nil {
+		s.fwdl.Error(err)
+	}
 }
 
 func (s *Server) checkDomainBlacklist(d string) bool {
-	_, ok := s.domainBlacklist[d]
-	return ok
+	return s.domainBlacklist.Load().(*domains.Matcher).Match(d)
 }
@@ -0,0 +1,410 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// StrikeBlocked is counted for queries to blacklisted or not whitelisted domains
+	StrikeBlocked = "blocked"
+	// StrikeNXDomain is counted for queries that were answered with NXDOMAIN
+	StrikeNXDomain = "nxdomain"
+	// StrikeMalformed is counted for queries without a question or with many questions
+	StrikeMalformed = "malformed"
+
+	defaultStrikeWindow = 60
+	defaultBanTime      = 300
+
+	// rrlPrefixV4 and rrlPrefixV6 group clients into networks for response rate
+	// limiting, since spoofed sources of an amplification attack vary within a network
+	rrlPrefixV4 = 24
+	rrlPrefixV6 = 56
+
+	rateLimitSweep = 10 * time.Second
+
+	// tcRetryWindow is how long after a truncated udp answer a tcp query from the
+	// same client counts as a retry that verifies the client's source address
+	tcRetryWindow = 10 * time.Second
+
+	edeTextRateLimited = "client is rate limited"
+	edeTextBanned      = "client is banned"
+)
+
+// RateLimit configures per client rate limiting. Every client can send QPS queries per second
+// with bursts of up to Burst queries (default 2*QPS). Blocked, NXDOMAIN and malformed queries
+// count as strikes, a client with StrikeLimit strikes within StrikeWindow seconds (default 60) is
+// banned for BanTime seconds (default 300). Limited and banned clients are refused. RRL limits
+// identical udp responses to RRL per second per client network, every RRLSlip'th limited response
+// is sent truncated so real clients retry over tcp, the rest are dropped (RRLSlip <= 0 drops all).
+// A value of 0 disables QPS, StrikeLimit or RRL
+type RateLimit struct {
+	QPS, Burst                         int
+	StrikeLimit, StrikeWindow, BanTime int
+	RRL, RRLSlip                       int
+}
+
+func (r RateLimit) withDefaults() RateLimit {
+	if r.Burst <= 0 {
+		r.Burst = 2 * r.QPS
+	}
+
+	if r.StrikeWindow <= 0 {
+		r.StrikeWindow = defaultStrikeWindow
+	}
+
+	if r.BanTime <= 0 {
+		r.BanTime = defaultBanTime
+	}
+
+	return r
+}
+
+// bucket is a token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket based on the time passed since the last call and takes a
+// token. Returns false if the bucket is empty
+func (b *bucket) take(now time.Time, rate, burst float64) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// client is the rate limiting state of a client ip. truncated is the last time a
+// truncated udp answer was sent to the client and verified the last time the client
+// retried such an answer over tcp
+type client struct {
+	queries     bucket
+	strikes     int
+	strikeStart time.Time
+	bannedUntil time.Time
+	truncated   time.Time
+	verified    time.Time
+}
+
+// rrlEntry is the response rate limiting state of a client network and response
+type rrlEntry struct {
+	responses bucket
+	limited   int
+}
+
+// rateLimiter keeps the rate limiting, strike and RRL state of all clients. Idle
+// state is swept every 10 seconds. Clients in exempt networks are never passed to onBan
+type rateLimiter struct {
+	sync.Mutex
+	RateLimit
+	clients map[string]*client
+	rrl     map[string]*rrlEntry
+	swept   time.Time
+	onBan   func(ip net.IP, d time.Duration)
+	exempt  []*net.IPNet
+}
+
+func newRateLimiter(r RateLimit) *rateLimiter {
+	return &rateLimiter{
+		RateLimit: r.withDefaults(),
+		clients:   make(map[string]*client),
+		rrl:       make(map[string]*rrlEntry),
+		swept:     time.Now(),
+	}
+}
+
+func (r *rateLimiter) enabled() bool {
+	return r.QPS > 0 || r.StrikeLimit > 0
+}
+
+// client returns the state of ip, creating it if needed. r must be locked
+func (r *rateLimiter) client(ip net.IP, now time.Time) *client {
+	if now.Sub(r.swept) > rateLimitSweep {
+		r.sweep(now)
+	}
+
+	c, ok := r.clients[ip.String()]
+	if !ok {
+		c = &client{}
+		r.clients[ip.String()] = c
+	}
+
+	return c
+}
+
+// sweep deletes the state of clients and responses that would be the same if they
+// were created again. r must be locked
+func (r *rateLimiter) sweep(now time.Time) {
+	window := time.Duration(r.StrikeWindow) * time.Second
+	for k, c := range r.clients {
+		idle := r.QPS == 0 || now.Sub(c.queries.last).Seconds()*float64(r.QPS) >= float64(r.Burst)
+		verified := now.Sub(c.verified) <= window || now.Sub(c.truncated) <= tcRetryWindow
+		if idle && !verified && now.After(c.bannedUntil) && now.Sub(c.strikeStart) > window {
+			delete(r.clients, k)
+		}
+	}
+
+	for k, e := range r.rrl {
+		if now.Sub(e.responses.last) > time.Second {
+			delete(r.rrl, k)
+		}
+	}
+
+	r.swept = now
+}
+
+// allow reports if a query from ip should be served. The second value is true
+// if the client is banned. A stream (tcp, dot, doh) query shortly after a truncated
+// udp answer verifies the client's source address
+func (r *rateLimiter) allow(ip net.IP, stream bool) (bool, bool) {
+	if ip == nil || !r.enabled() {
+		return true, false
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	c := r.client(ip, now)
+
+	if stream && now.Sub(c.truncated) <= tcRetryWindow {
+		c.verified = now
+	}
+
+	if now.Before(c.bannedUntil) {
+		return false, true
+	}
+
+	if r.QPS > 0 && !c.queries.take(now, float64(r.QPS), float64(r.Burst)) {
+		return false, false
+	}
+
+	return true, false
+}
+
+// truncated records that a truncated udp answer was sent to ip
+func (r *rateLimiter) truncated(ip net.IP) {
+	if ip == nil || r.StrikeLimit <= 0 {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	r.client(ip, now).truncated = now
+}
+
+// strike counts a strike for ip and bans the client once it reaches the strike limit
+// within the strike window. Since udp sources can be spoofed, a ban is passed to onBan
+// only if the strike came over a stream (tcp, dot, doh) or the client has retried a
+// truncated udp answer over tcp within the strike window. Returns true if the client
+// was banned, and true if the ban was passed to onBan
+func (r *rateLimiter) strike(ip net.IP, stream bool) (bool, bool) {
+	if ip == nil || r.StrikeLimit <= 0 {
+		return false, false
+	}
+
+	r.Lock()
+
+	now := time.Now()
+	c := r.client(ip, now)
+
+	if now.Sub(c.strikeStart) > time.Duration(r.StrikeWindow)*time.Second {
+		c.strikes = 0
+		c.strikeStart = now
+	}
+	c.strikes++
+
+	if c.strikes < r.StrikeLimit || now.Before(c.bannedUntil) {
+		r.Unlock()
+		return false, false
+	}
+
+	banTime := time.Duration(r.BanTime) * time.Second
+	c.bannedUntil = now.Add(banTime)
+	c.strikes = 0
+	verified := stream || now.Sub(c.verified) <= time.Duration(r.StrikeWindow)*time.Second
+	onBan := r.onBan
+	if !verified || ip.IsLoopback() || containsIP(r.exempt, ip) {
+		onBan = nil
+	}
+	r.Unlock()
+
+	if onBan == nil {
+		return true, false
+	}
+	onBan(ip, banTime)
+
+	return true, true
+}
+
+// respond applies response rate limiting to a udp response for ip. Returns false if the
+// response should be dropped, and true with slip set if it should be sent truncated
+func (r *rateLimiter) respond(ip net.IP, resp *dns.Msg) (bool, bool) {
+	if ip == nil || r.RRL <= 0 {
+		return true, false
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.swept) > rateLimitSweep {
+		r.sweep(now)
+	}
+
+	k := rrlKey(ip, resp)
+	e, ok := r.rrl[k]
+	if !ok {
+		e = &rrlEntry{}
+		r.rrl[k] = e
+	}
+
+	if e.responses.take(now, float64(r.RRL), float64(r.RRL)) {
+		return true, false
+	}
+
+	e.limited++
+	if r.RRLSlip > 0 && e.limited%r.RRLSlip == 0 {
+		return true, true
+	}
+
+	return false, false
+}
+
+// rrlKey groups responses by client network and answer. Errors and NXDOMAIN answers
+// are grouped by rcode only, so random names do not get a bucket each
+func rrlKey(ip net.IP, resp *dns.Msg) string {
+	prefix := net.CIDRMask(rrlPrefixV6, 8*net.IPv6len)
+	if ip.To4() != nil {
+		ip = ip.To4()
+		prefix = net.CIDRMask(rrlPrefixV4, 8*net.IPv4len)
+	}
+
+	key := []string{ip.Mask(prefix).String(), dns.RcodeToString[resp.Rcode]}
+	if resp.Rcode == dns.RcodeSuccess && len(resp.Question) > 0 {
+		q := resp.Question[0]
+		key = append(key, strings.ToLower(q.Name), dns.TypeToString[q.Qtype])
+	}
+
+	return strings.Join(key, " ")
+}
+
+// SetBanHandler sets a function that is called when a client is banned, for example to
+// drop its traffic in the firewall for the ban duration. Only clients with a verified
+// source address are passed to the handler, see rateLimiter.strike. Loopback clients,
+// NetTrust's listener and upstream addresses and clients in exempt (networks in CIDR
+// notation or single IPs) are never passed to the handler, they are only refused
+func (s *Server) SetBanHandler(fn func(ip net.IP, d time.Duration), exempt []string) error {
+	nets, err := parseNetworks(exempt)
+	if err != nil {
+		return err
+	}
+
+	s.limiter.Lock()
+	defer s.limiter.Unlock()
+
+	s.limiter.onBan = fn
+	s.limiter.exempt = append(s.ownNetworks(), nets...)
+
+	return nil
+}
+
+// ownNetworks returns the IPs of the listeners and upstreams of s as single IP networks.
+// Unspecified listener addresses and upstreams given by name are skipped
+func (s *Server) ownNetworks() []*net.IPNet {
+	addrs := []string{}
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr)
+	}
+	for _, u := range s.forwarder.upstreams {
+		addrs = append(addrs, u.Addr)
+	}
+	for _, r := range s.forwardRules {
+		for _, u := range r.forwarder.upstreams {
+			addrs = append(addrs, u.Addr)
+		}
+	}
+
+	ips := []string{}
+	for _, a := range addrs {
+		host, _, err := net.SplitHostPort(a)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			ips = append(ips, ip.String())
+		}
+	}
+
+	nets, _ := parseNetworks(ips)
+
+	return nets
+}
+
+// limitClient checks a query against the client rate limit and ban list before the query
+// is passed to fwd. Limited and banned clients are refused. Returns false if the query
+// should not be served
+func (s *Server) limitClient(w dns.ResponseWriter, req *dns.Msg) bool {
+	ok, banned := s.limiter.allow(clientIP(w), isStream(w))
+	if ok {
+		return true
+	}
+
+	text := edeTextRateLimited
+	if banned {
+		text = edeTextBanned
+		s.fwdl.Debugf(infoClientBanned, w.RemoteAddr())
+	} else {
+		s.fwdl.Debugf(infoClientRateLimited, w.RemoteAddr())
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeRefused)
+	setEDE(m, dns.ExtendedErrorCodeProhibited, text)
+
+	err := s.writeMsg(w, req, m)
+	if err != nil {
+		s.fwdl.Error(err)
+	}
+
+	return false
+}
+
+// strike counts a strike for the client of a query
+func (s *Server) strike(w dns.ResponseWriter, reason string) {
+	banned, handled := s.limiter.strike(clientIP(w), isStream(w))
+	if !banned {
+		return
+	}
+
+	s.fwdl.Warnf(warnClientBanned, w.RemoteAddr(), s.limiter.StrikeLimit, reason, s.limiter.BanTime)
+	if !handled {
+		s.fwdl.Debugf(infoClientBannedDNS, w.RemoteAddr())
+	}
+}
+
+// isStream reports if a query came over a stream (tcp, dot, doh), whose source
+// address can not be spoofed
+func isStream(w dns.ResponseWriter) bool {
+	_, ok := w.RemoteAddr().(*net.TCPAddr)
+	return ok
+}
@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ClientAuthNone does not ask dot and doh clients for a certificate
+	ClientAuthNone = "none"
+	// ClientAuthRequest asks clients for a certificate but does not require or verify it
+	ClientAuthRequest = "request"
+	// ClientAuthRequire requires clients to send a certificate but does not verify it
+	ClientAuthRequire = "require"
+	// ClientAuthVerifyIfGiven verifies the client certificate against the client CA if one is sent
+	ClientAuthVerifyIfGiven = "verify-if-given"
+	// ClientAuthRequireAndVerify requires a client certificate that is signed by the client CA
+	ClientAuthRequireAndVerify = "require-and-verify"
+)
+
+// listenerTLSConfig returns the tls config of a dot or doh listener. If a client CA
+// is set and no client auth mode is given, clients are required to present a certificate
+// signed by the client CA. Every verified client connection is logged with its identity.
+// The certificate and the client CA are read on every handshake, so reloaded files are
+// used by new connections
+func listenerTLSConfig(ln *listener, logger *logrus.Entry) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return ln.cert.certificate(), nil
+		},
+	}
+
+	// http.Server adds h2 to the config it serves, but not to configs
+	// returned by GetConfigForClient
+	if ln.Proto == ListenerDoH {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	mode := ln.ClientAuth
+	if mode == "" && ln.ClientCaCert != "" {
+		mode = ClientAuthRequireAndVerify
+	}
+
+	switch mode {
+	case "", ClientAuthNone:
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case ClientAuthRequest:
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	case ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	case ClientAuthVerifyIfGiven:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequireAndVerify:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf(errListenClientAuth, mode, ln.Addr)
+	}
+
+	if mode == ClientAuthVerifyIfGiven || mode == ClientAuthRequireAndVerify {
+		if ln.ClientCaCert == "" {
+			return nil, fmt.Errorf(errListenClientCa, mode, ln.Addr)
+		}
+
+		clientCA, err := newCaPool(ln.ClientCaCert)
+		if err != nil {
+			return nil, err
+		}
+		ln.clientCA = clientCA
+
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+				logger.Infof(infoTLSClient, certIdentity(state.VerifiedChains[0][0]), ln.Proto, ln.Addr)
+			}
+			return nil
+		}
+
+		base := tlsConfig
+		tlsConfig = base.Clone()
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := base.Clone()
+			c.ClientCAs = clientCA.pool()
+			return c, nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// clientIdentity returns the identity of a client that presented a verified
+// certificate over dot or doh, or an empty string if there is none
+func clientIdentity(w dns.ResponseWriter) string {
+	cs, ok := w.(dns.ConnectionStater)
+	if !ok {
+		return ""
+	}
+
+	state := cs.ConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return certIdentity(state.VerifiedChains[0][0])
+}
+
+// certIdentity returns the common name of a certificate, or its first
+// DNS name if the common name is empty
+func certIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+
+	return cert.Subject.String()
+}
@@ -0,0 +1,400 @@
+package dns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// StrategyFailover asks upstreams in the order they were given and moves to the next one on error
+	StrategyFailover = "failover"
+	// StrategyRoundRobin rotates the first upstream that is asked on every query
+	StrategyRoundRobin = "round-robin"
+	// StrategyLowestLatency asks first the upstream with the lowest measured round trip time
+	StrategyLowestLatency = "lowest-latency"
+	// StrategyParallel asks all upstreams at once and uses the first successful answer
+	StrategyParallel = "parallel"
+
+	defaultUpstreamTimeout = 2000
+)
+
+// Upstream describes a forward dns server. Proto can be udp, tcp or https (DoH).
+// Path, Method and ServerName are used only by DoH upstreams. Timeout is the exchange
+// timeout in milliseconds (default 2000, 5000 for DoH), a failed exchange is retried Retries times
+// before the next upstream is asked. ClientCert and ClientKey are presented to DoT and DoH
+// upstreams that require client certificates
+type Upstream struct {
+	Addr, Proto, CaCert      string
+	ClientCert, ClientKey    string
+	Path, Method, ServerName string
+	TLS                      bool
+	Timeout, Retries         int
+}
+
+// exchanger is implemented by dns.Client and dohClient
+type exchanger interface {
+	Exchange(m *dns.Msg, address string) (*dns.Msg, time.Duration, error)
+}
+
+// upstream is a configured forward dns server along with its client, latency stats
+// and circuit breaker. udp upstreams also have a tcp client for truncated answers
+type upstream struct {
+	sync.Mutex
+	Upstream
+	client     exchanger
+	tcp        exchanger
+	caPool     *certFile
+	clientCert *certFile
+	rtt        time.Duration
+	health     UpstreamHealth
+	circuit    circuit
+	logger     *logrus.Entry
+}
+
+// exchange forwards a query to the upstream, retrying up to Retries times, and records
+// the result in the circuit breaker. Truncated udp answers are asked again over tcp, so
+// that the full answer is authorized. A moving average of the round trip time is kept,
+// failed exchanges count as a round trip of the upstream's timeout
+func (u *upstream) exchange(req *dns.Msg) (*dns.Msg, error) {
+	var err error
+	for i := 0; i <= u.Retries; i++ {
+		var resp *dns.Msg
+		var rtt time.Duration
+
+		resp, rtt, err = u.client.Exchange(req, u.Addr)
+		if err == nil && resp.Truncated && u.tcp != nil {
+			u.logger.Debugf(infoTruncatedRetryTCP, req.Question[0].Name, u.Addr)
+			resp, rtt, err = u.tcp.Exchange(req, u.Addr)
+		}
+		if err == nil {
+			u.success(rtt)
+			return resp, nil
+		}
+	}
+
+	u.failure(err)
+
+	return nil, err
+}
+
+func (u *upstream) latency() time.Duration {
+	u.Lock()
+	defer u.Unlock()
+	return u.rtt
+}
+
+func newUpstream(up Upstream, udpBufferSize uint16, health UpstreamHealth, logger *logrus.Entry) (*upstream, error) {
+	if up.Addr == "" {
+		return nil, fmt.Errorf(errFWDNSAddr)
+	}
+
+	if up.Proto == "" {
+		up.Proto = "udp"
+	}
+
+	if up.TLS && up.Proto != "tcp" && up.Proto != "https" {
+		return nil, fmt.Errorf(errFWDTLS)
+	}
+
+	if up.Proto != "udp" && up.Proto != "tcp" && up.Proto != "https" {
+		return nil, fmt.Errorf(errFWDNSProto)
+	}
+
+	host, port, err := net.SplitHostPort(up.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if host == "" || port == "" {
+		return nil, fmt.Errorf(errFWDNSAddrInvalid, host, port)
+	}
+
+	if up.TLS && port == "53" {
+		logger.Warnf(warnFWDTLSPort, up.Addr)
+	}
+
+	if up.Timeout <= 0 && up.Proto == "https" {
+		up.Timeout = int(dohTimeout / time.Millisecond)
+	} else if up.Timeout <= 0 {
+		up.Timeout = defaultUpstreamTimeout
+	}
+	timeout := time.Duration(up.Timeout) * time.Millisecond
+
+	if up.Retries < 0 {
+		up.Retries = 0
+	}
+
+	u := &upstream{
+		Upstream: up,
+		health:   health,
+		circuit: circuit{
+			state: CircuitClosed,
+			since: time.Now(),
+		},
+		logger: logger,
+	}
+
+	if (up.ClientCert == "") != (up.ClientKey == "") {
+		return nil, fmt.Errorf(errFWDClientCert, up.Addr)
+	}
+
+	var tlsConfig *tls.Config
+	if up.TLS || up.Proto == "https" {
+		tlsConfig, err = u.tlsConfig(host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if up.Proto == "https" {
+		client, err := newDoHClient(up.Addr, up.Path, up.Method, up.ServerName, timeout, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		u.client = client
+		return u, nil
+	}
+
+	client := &dns.Client{Net: up.Proto, UDPSize: udpBufferSize, TLSConfig: tlsConfig, Timeout: timeout}
+	if up.TLS {
+		client.Net = "tcp-tls"
+	}
+	u.client = client
+
+	if up.Proto == "udp" {
+		u.tcp = &dns.Client{Net: "tcp", Timeout: timeout}
+	}
+
+	return u, nil
+}
+
+// tlsConfig returns the tls config of a DoT or DoH upstream. The CA and the client
+// certificate are read on every handshake, so reloaded files are used by new connections
+func (u *upstream) tlsConfig(host string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if u.CaCert != "" {
+		caPool, err := newCaPool(u.CaCert)
+		if err != nil {
+			return nil, err
+		}
+		u.caPool = caPool
+
+		serverName := host
+		if u.Proto == "https" && u.ServerName != "" {
+			serverName = u.ServerName
+		}
+
+		// RootCAs can not be swapped on a config that is in use, the upstream
+		// certificate is verified here against the current CA pool instead
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf(errNoCertificates, u.Addr)
+			}
+
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         caPool.pool(),
+				Intermediates: intermediates,
+			})
+			return err
+		}
+	}
+
+	if u.ClientCert != "" {
+		clientCert, err := newKeyPair(u.ClientCert, u.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		u.clientCert = clientCert
+
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return clientCert.certificate(), nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// forwarder picks upstreams based on the configured strategy
+type forwarder struct {
+	strategy  string
+	upstreams []*upstream
+	next      uint32
+	udpSize   uint16
+	logger    *logrus.Entry
+}
+
+func newForwarder(upstreams []Upstream, strategy string, udpBufferSize uint16, health UpstreamHealth, logger *logrus.Entry) (*forwarder, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf(errFWDNSAddr)
+	}
+
+	if strategy == "" {
+		strategy = StrategyFailover
+	}
+
+	if udpBufferSize < dns.MinMsgSize {
+		udpBufferSize = dns.DefaultMsgSize
+	}
+
+	switch strategy {
+	case StrategyFailover, StrategyRoundRobin, StrategyLowestLatency, StrategyParallel:
+	default:
+		return nil, fmt.Errorf(errFWDStrategy, strategy)
+	}
+
+	fwd := &forwarder{
+		strategy: strategy,
+		udpSize:  udpBufferSize,
+		logger:   logger,
+	}
+
+	for _, up := range upstreams {
+		u, err := newUpstream(up, udpBufferSize, health, logger)
+		if err != nil {
+			return nil, err
+		}
+		fwd.upstreams = append(fwd.upstreams, u)
+	}
+
+	return fwd, nil
+}
+
+// exchange forwards a query to the upstreams based on the forwarder strategy. Upstreams
+// with an open circuit are skipped. If no upstream is available all of them are asked,
+// since trying is better than failing without asking
+func (f *forwarder) exchange(req *dns.Msg) (*dns.Msg, error) {
+	req = upstreamQuery(req, f.udpSize)
+	ordered := f.order()
+
+	if f.strategy == StrategyParallel && len(f.upstreams) > 1 {
+		upstreams := []*upstream{}
+		for _, u := range ordered {
+			if u.available() {
+				upstreams = append(upstreams, u)
+			}
+		}
+
+		if len(upstreams) == 0 {
+			f.logger.Warn(warnAllCircuitsOpen)
+			upstreams = ordered
+		}
+
+		return f.race(req, upstreams)
+	}
+
+	var err error
+	var resp *dns.Msg
+	asked := 0
+	for _, u := range ordered {
+		// available is checked right before asking, since it lets a
+		// single trial query through half-open circuits
+		if !u.available() {
+			continue
+		}
+
+		asked++
+		resp, err = u.exchange(req)
+		if err == nil {
+			return resp, nil
+		}
+		f.logger.Warnf(warnUpstreamFailed, u.Addr, err)
+	}
+
+	if asked > 0 {
+		return nil, err
+	}
+
+	f.logger.Warn(warnAllCircuitsOpen)
+	for _, u := range ordered {
+		resp, err = u.exchange(req)
+		if err == nil {
+			return resp, nil
+		}
+		f.logger.Warnf(warnUpstreamFailed, u.Addr, err)
+	}
+
+	return nil, err
+}
+
+// order returns the upstreams in the order they should be asked
+func (f *forwarder) order() []*upstream {
+	n := len(f.upstreams)
+	ordered := make([]*upstream, 0, n)
+
+	switch f.strategy {
+	case StrategyRoundRobin:
+		start := int((atomic.AddUint32(&f.next, 1) - 1) % uint32(n))
+		for i := 0; i < n; i++ {
+			ordered = append(ordered, f.upstreams[(start+i)%n])
+		}
+	case StrategyLowestLatency:
+		ordered = append(ordered, f.upstreams...)
+		// Insertion sort, upstream lists are small. Upstreams without
+		// measurements have 0 rtt and are asked after measured ones
+		for i := 1; i < n; i++ {
+			for j := i; j > 0 && fasterThan(ordered[j].latency(), ordered[j-1].latency()); j-- {
+				ordered[j], ordered[j-1] = ordered[j-1], ordered[j]
+			}
+		}
+	default:
+		ordered = append(ordered, f.upstreams...)
+	}
+
+	return ordered
+}
+
+// fasterThan reports if an upstream with rtt a should be asked before one with rtt b.
+// An rtt of 0 means the upstream has not been measured yet
+func fasterThan(a, b time.Duration) bool {
+	if a == 0 {
+		return false
+	}
+
+	return b == 0 || a < b
+}
+
+// race asks the given upstreams at once and returns the first successful answer
+func (f *forwarder) race(req *dns.Msg, upstreams []*upstream) (*dns.Msg, error) {
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+
+	results := make(chan result, len(upstreams))
+	for _, u := range upstreams {
+		go func(u *upstream, m *dns.Msg) {
+			resp, err := u.exchange(m)
+			if err != nil {
+				f.logger.Warnf(warnUpstreamFailed, u.Addr, err)
+			}
+			results <- result{resp: resp, err: err}
+		}(u, req.Copy())
+	}
+
+	var err error
+	for range upstreams {
+		r := <-results
+		if r.err == nil {
+			return r.resp, nil
+		}
+		err = r.err
+	}
+
+	return nil, err
+}
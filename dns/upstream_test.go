@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestForwarder(strategy string, rtts ...time.Duration) *forwarder {
+	f := &forwarder{strategy: strategy}
+	for i, rtt := range rtts {
+		f.upstreams = append(f.upstreams, &upstream{
+			Upstream: Upstream{Addr: string(rune('a' + i))},
+			rtt:      rtt,
+		})
+	}
+
+	return f
+}
+
+func addrs(upstreams []*upstream) []string {
+	out := []string{}
+	for _, u := range upstreams {
+		out = append(out, u.Addr)
+	}
+
+	return out
+}
+
+func TestOrder(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name     string
+		strategy string
+		rtts     []time.Duration
+		next     uint32
+		want     [][]string
+	}{
+		{
+			name:     "failover keeps the configured order",
+			strategy: StrategyFailover,
+			rtts:     []time.Duration{30 * ms, 10 * ms, 20 * ms},
+			want:     [][]string{{"a", "b", "c"}, {"a", "b", "c"}},
+		},
+		{
+			name:     "parallel keeps the configured order",
+			strategy: StrategyParallel,
+			rtts:     []time.Duration{30 * ms, 10 * ms},
+			want:     [][]string{{"a", "b"}},
+		},
+		{
+			name:     "round-robin rotates the first upstream",
+			strategy: StrategyRoundRobin,
+			rtts:     []time.Duration{0, 0, 0},
+			want:     [][]string{{"a", "b", "c"}, {"b", "c", "a"}, {"c", "a", "b"}, {"a", "b", "c"}},
+		},
+		{
+			name:     "round-robin wraps the counter",
+			strategy: StrategyRoundRobin,
+			rtts:     []time.Duration{0, 0, 0},
+			next:     math.MaxUint32,
+			// MaxUint32 % 3 == 0, the counter then wraps to 0
+			want: [][]string{{"a", "b", "c"}, {"a", "b", "c"}, {"b", "c", "a"}},
+		},
+		{
+			name:     "round-robin past 2^31",
+			strategy: StrategyRoundRobin,
+			rtts:     []time.Duration{0, 0},
+			next:     math.MaxInt32 + 1,
+			want:     [][]string{{"a", "b"}, {"b", "a"}},
+		},
+		{
+			name:     "lowest-latency sorts by rtt",
+			strategy: StrategyLowestLatency,
+			rtts:     []time.Duration{30 * ms, 10 * ms, 20 * ms},
+			want:     [][]string{{"b", "c", "a"}},
+		},
+		{
+			name:     "lowest-latency asks unmeasured upstreams last",
+			strategy: StrategyLowestLatency,
+			rtts:     []time.Duration{0, 20 * ms, 0, 10 * ms},
+			want:     [][]string{{"d", "b", "a", "c"}},
+		},
+		{
+			name:     "lowest-latency keeps the order of equal rtts",
+			strategy: StrategyLowestLatency,
+			rtts:     []time.Duration{10 * ms, 10 * ms, 5 * ms},
+			want:     [][]string{{"c", "a", "b"}},
+		},
+		{
+			name:     "single upstream",
+			strategy: StrategyRoundRobin,
+			rtts:     []time.Duration{0},
+			want:     [][]string{{"a"}, {"a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestForwarder(tt.strategy, tt.rtts...)
+			f.next = tt.next
+
+			for i, want := range tt.want {
+				if got := addrs(f.order()); !reflect.DeepEqual(got, want) {
+					t.Errorf("query %d: order() = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFasterThan(t *testing.T) {
+	tests := []struct {
+		a, b   time.Duration
+		faster bool
+	}{
+		{time.Millisecond, 2 * time.Millisecond, true},
+		{2 * time.Millisecond, time.Millisecond, false},
+		{time.Millisecond, time.Millisecond, false},
+		{time.Millisecond, 0, true},
+		{0, time.Millisecond, false},
+		{0, 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := fasterThan(tt.a, tt.b); got != tt.faster {
+			t.Errorf("fasterThan(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.faster)
+		}
+	}
+}
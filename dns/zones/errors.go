@@ -0,0 +1,6 @@
+package zones
+
+var (
+	errRecord   string = "[%s] is not a valid record: %s"
+	errZoneFile string = "could not parse zone file %s: %s"
+)
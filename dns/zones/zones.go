@@ -0,0 +1,264 @@
+package zones
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain limits how many local CNAMEs are followed when answering a query
+const maxCNAMEChain = 8
+
+// Zone describes a local zone. Records are given in zone file format, e.g.
+// "host.lan. 300 IN A 192.168.1.10". If File is set, records are also read from it.
+// If Origin is set, NetTrust is authoritative for it and answers NXDOMAIN for any
+// name under the origin that has no records, instead of forwarding the query
+type Zone struct {
+	Origin, File string
+	Records      []string
+}
+
+type key struct {
+	name  string
+	qtype uint16
+}
+
+// Zones for answering queries from local records
+type Zones struct {
+	records map[key][]dns.RR
+	names   map[string]struct{}
+	soa     map[string]dns.RR
+	origins []string
+}
+
+// NewZones creates Zones from a list of zone definitions. A PTR record is created
+// for every A/AAAA record, unless a PTR for the same address has been given
+func NewZones(zones []Zone) (*Zones, error) {
+	z := &Zones{
+		records: make(map[key][]dns.RR),
+		names:   make(map[string]struct{}),
+		soa:     make(map[string]dns.RR),
+	}
+
+	for _, zone := range zones {
+		origin := ""
+		if zone.Origin != "" {
+			origin = dns.Fqdn(strings.ToLower(zone.Origin))
+			z.origins = append(z.origins, origin)
+		}
+
+		for _, r := range zone.Records {
+			rr, err := dns.NewRR(r)
+			if err != nil {
+				return nil, fmt.Errorf(errRecord, r, err)
+			}
+			if rr == nil {
+				continue
+			}
+			z.add(rr)
+		}
+
+		if zone.File == "" {
+			continue
+		}
+
+		err := z.loadFile(zone.File, origin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	z.addPTRs()
+
+	return z, nil
+}
+
+func (z *Zones) loadFile(file, origin string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zp := dns.NewZoneParser(f, origin, file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		z.add(rr)
+	}
+
+	if err := zp.Err(); err != nil {
+		return fmt.Errorf(errZoneFile, file, err)
+	}
+
+	return nil
+}
+
+func (z *Zones) add(rr dns.RR) {
+	h := rr.Header()
+	h.Name = strings.ToLower(dns.Fqdn(h.Name))
+
+	if h.Rrtype == dns.TypeSOA {
+		z.soa[h.Name] = rr
+	}
+
+	k := key{name: h.Name, qtype: h.Rrtype}
+	z.records[k] = append(z.records[k], rr)
+	z.names[h.Name] = struct{}{}
+}
+
+func (z *Zones) addPTRs() {
+	ptrs := []dns.RR{}
+	for k, rrs := range z.records {
+		if k.qtype != dns.TypeA && k.qtype != dns.TypeAAAA {
+			continue
+		}
+
+		for _, rr := range rrs {
+			var ip net.IP
+			switch r := rr.(type) {
+			case *dns.A:
+				ip = r.A
+			case *dns.AAAA:
+				ip = r.AAAA
+			}
+
+			rev, err := dns.ReverseAddr(ip.String())
+			if err != nil {
+				continue
+			}
+
+			if _, ok := z.records[key{name: rev, qtype: dns.TypePTR}]; ok {
+				continue
+			}
+
+			ptrs = append(ptrs, &dns.PTR{
+				Hdr: dns.RR_Header{
+					Name:   rev,
+					Rrtype: dns.TypePTR,
+					Class:  dns.ClassINET,
+					Ttl:    rr.Header().Ttl,
+				},
+				Ptr: k.name,
+			})
+		}
+	}
+
+	for _, ptr := range ptrs {
+		z.add(ptr)
+	}
+}
+
+// Len returns the number of local records
+func (z *Zones) Len() int {
+	if z == nil {
+		return 0
+	}
+
+	n := 0
+	for _, rrs := range z.records {
+		n += len(rrs)
+	}
+
+	return n
+}
+
+// Lookup answers a query from the local records. It returns false if the query
+// should be forwarded upstream
+func (z *Zones) Lookup(req *dns.Msg) (*dns.Msg, bool) {
+	if z == nil || len(z.records) == 0 {
+		return nil, false
+	}
+
+	q := req.Question[0]
+	name := strings.ToLower(dns.Fqdn(q.Name))
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	resp.RecursionAvailable = true
+
+	for i := 0; i < maxCNAMEChain; i++ {
+		if rrs, ok := z.records[key{name: name, qtype: q.Qtype}]; ok {
+			resp.Answer = append(resp.Answer, copyRRs(rrs, q.Name, i == 0)...)
+			return resp, true
+		}
+
+		cname, ok := z.records[key{name: name, qtype: dns.TypeCNAME}]
+		if !ok || q.Qtype == dns.TypeCNAME {
+			break
+		}
+
+		resp.Answer = append(resp.Answer, copyRRs(cname, q.Name, i == 0)...)
+		name = strings.ToLower(cname[0].(*dns.CNAME).Target)
+	}
+
+	// The CNAME chain leads to a name without local records of the
+	// requested type. See Unresolved for resolving the rest of the chain
+	if len(resp.Answer) > 0 {
+		return resp, true
+	}
+
+	// Names with local records answer NODATA for other types. Names under
+	// a local origin without any records answer NXDOMAIN
+	origin := z.origin(name)
+	if _, ok := z.names[name]; !ok {
+		if origin == "" {
+			return nil, false
+		}
+		resp.Rcode = dns.RcodeNameError
+	}
+
+	if soa, ok := z.soa[origin]; ok {
+		resp.Ns = append(resp.Ns, dns.Copy(soa))
+	}
+
+	return resp, true
+}
+
+// Unresolved returns the target of the last CNAME in a local answer if the
+// chain leads outside of the local zones, otherwise an empty string
+func (z *Zones) Unresolved(resp *dns.Msg) string {
+	if len(resp.Answer) == 0 || resp.Question[0].Qtype == dns.TypeCNAME {
+		return ""
+	}
+
+	cname, ok := resp.Answer[len(resp.Answer)-1].(*dns.CNAME)
+	if !ok {
+		return ""
+	}
+
+	if z.origin(strings.ToLower(cname.Target)) != "" {
+		return ""
+	}
+
+	return cname.Target
+}
+
+// origin returns the longest origin that contains name
+func (z *Zones) origin(name string) string {
+	o := ""
+	for _, origin := range z.origins {
+		if dns.IsSubDomain(origin, name) && len(origin) > len(o) {
+			o = origin
+		}
+	}
+
+	return o
+}
+
+// copyRRs returns copies of rrs. If keepCase is true, the owner name is set to qname
+// so the answer matches the case of the question
+func copyRRs(rrs []dns.RR, qname string, keepCase bool) []dns.RR {
+	out := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		c := dns.Copy(rr)
+		if keepCase {
+			c.Header().Name = qname
+		}
+		out = append(out, c)
+	}
+
+	return out
+}
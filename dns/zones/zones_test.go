@@ -0,0 +1,219 @@
+package zones
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newZones(t *testing.T) *Zones {
+	t.Helper()
+
+	z, err := NewZones([]Zone{{
+		Origin: "lan.",
+		Records: []string{
+			"lan. 300 IN SOA ns.lan. admin.lan. 1 3600 600 86400 60",
+			"nas.lan. 300 IN A 192.168.1.20",
+			"nas.lan. 300 IN AAAA fd00::20",
+			"files.lan. 300 IN CNAME nas.lan.",
+			"share.lan. 300 IN CNAME files.lan.",
+			"cdn.lan. 300 IN CNAME cdn.example.com.",
+			"router.lan. 300 IN A 192.168.1.1",
+			"1.1.168.192.in-addr.arpa. 300 IN PTR gateway.lan.",
+			"loop1.lan. 300 IN CNAME loop2.lan.",
+			"loop2.lan. 300 IN CNAME loop1.lan.",
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return z
+}
+
+func query(name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	return m
+}
+
+func TestLookup(t *testing.T) {
+	z := newZones(t)
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		found  bool
+		rcode  int
+		answer []string
+		soa    bool
+	}{
+		{
+			name:   "nas.lan.",
+			qtype:  dns.TypeA,
+			found:  true,
+			answer: []string{"nas.lan.\t300\tIN\tA\t192.168.1.20"},
+		},
+		{
+			name:   "NAS.lan.",
+			qtype:  dns.TypeA,
+			found:  true,
+			answer: []string{"NAS.lan.\t300\tIN\tA\t192.168.1.20"},
+		},
+		{
+			name:  "share.lan.",
+			qtype: dns.TypeAAAA,
+			found: true,
+			answer: []string{
+				"share.lan.\t300\tIN\tCNAME\tfiles.lan.",
+				"files.lan.\t300\tIN\tCNAME\tnas.lan.",
+				"nas.lan.\t300\tIN\tAAAA\tfd00::20",
+			},
+		},
+		{
+			name:   "files.lan.",
+			qtype:  dns.TypeCNAME,
+			found:  true,
+			answer: []string{"files.lan.\t300\tIN\tCNAME\tnas.lan."},
+		},
+		{
+			name:   "cdn.lan.",
+			qtype:  dns.TypeA,
+			found:  true,
+			answer: []string{"cdn.lan.\t300\tIN\tCNAME\tcdn.example.com."},
+		},
+		{
+			name:  "router.lan.",
+			qtype: dns.TypeTXT,
+			found: true,
+			soa:   true,
+		},
+		{
+			name:  "missing.lan.",
+			qtype: dns.TypeA,
+			found: true,
+			rcode: dns.RcodeNameError,
+			soa:   true,
+		},
+		{
+			name:  "example.com.",
+			qtype: dns.TypeA,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.name, dns.TypeToString[tt.qtype]), func(t *testing.T) {
+			resp, found := z.Lookup(query(tt.name, tt.qtype))
+			if found != tt.found {
+				t.Fatalf("found = %v, want %v", found, tt.found)
+			}
+			if !found {
+				return
+			}
+
+			if resp.Rcode != tt.rcode {
+				t.Errorf("rcode = %d, want %d", resp.Rcode, tt.rcode)
+			}
+
+			if len(resp.Answer) != len(tt.answer) {
+				t.Fatalf("answer = %v, want %v", resp.Answer, tt.answer)
+			}
+			for i, rr := range resp.Answer {
+				if rr.String() != tt.answer[i] {
+					t.Errorf("answer %d = %q, want %q", i, rr.String(), tt.answer[i])
+				}
+			}
+
+			if hasSOA := len(resp.Ns) == 1 && resp.Ns[0].Header().Rrtype == dns.TypeSOA; hasSOA != tt.soa {
+				t.Errorf("soa in authority = %v, want %v", hasSOA, tt.soa)
+			}
+		})
+	}
+}
+
+func TestLookupCNAMELoop(t *testing.T) {
+	z := newZones(t)
+
+	resp, found := z.Lookup(query("loop1.lan.", dns.TypeA))
+	if !found {
+		t.Fatal("loop was not answered locally")
+	}
+
+	if len(resp.Answer) != maxCNAMEChain {
+		t.Errorf("answer has %d records, want %d", len(resp.Answer), maxCNAMEChain)
+	}
+}
+
+func TestUnresolved(t *testing.T) {
+	z := newZones(t)
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		target string
+	}{
+		{"cdn.lan.", dns.TypeA, "cdn.example.com."},
+		{"cdn.lan.", dns.TypeCNAME, ""},
+		{"share.lan.", dns.TypeA, ""},
+		{"nas.lan.", dns.TypeA, ""},
+		{"loop1.lan.", dns.TypeA, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.name, dns.TypeToString[tt.qtype]), func(t *testing.T) {
+			resp, _ := z.Lookup(query(tt.name, tt.qtype))
+			if got := z.Unresolved(resp); got != tt.target {
+				t.Errorf("Unresolved() = %q, want %q", got, tt.target)
+			}
+		})
+	}
+}
+
+func TestPTRSynthesis(t *testing.T) {
+	z := newZones(t)
+
+	tests := []struct {
+		name string
+		ptr  string
+	}{
+		{"20.1.168.192.in-addr.arpa.", "nas.lan."},
+		{"0.2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", "nas.lan."},
+		// An explicit PTR is not replaced by a synthesized one
+		{"1.1.168.192.in-addr.arpa.", "gateway.lan."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, found := z.Lookup(query(tt.name, dns.TypePTR))
+			if !found || len(resp.Answer) != 1 {
+				t.Fatalf("Lookup() = %v, %v", resp, found)
+			}
+
+			ptr, ok := resp.Answer[0].(*dns.PTR)
+			if !ok || ptr.Ptr != tt.ptr {
+				t.Errorf("answer = %v, want PTR %s", resp.Answer[0], tt.ptr)
+			}
+		})
+	}
+
+	if _, found := z.Lookup(query("9.9.9.9.in-addr.arpa.", dns.TypePTR)); found {
+		t.Error("PTR for an address without local records was answered locally")
+	}
+}
+
+func TestNewZonesInvalidRecord(t *testing.T) {
+	if _, err := NewZones([]Zone{{Records: []string{"nas.lan. 300 IN A not-an-ip"}}}); err == nil {
+		t.Error("NewZones() did not fail for an invalid record")
+	}
+}
+
+func TestNilZones(t *testing.T) {
+	var z *Zones
+	if _, found := z.Lookup(query("nas.lan.", dns.TypeA)); found {
+		t.Error("nil zones answered a query")
+	}
+	if z.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", z.Len())
+	}
+}
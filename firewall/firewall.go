@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/ulfox/nettrust/firewall/nftables"
@@ -27,6 +28,23 @@ type backend interface {
 	CreateIPv4Table(t string) error
 	CreateIPv4Chain(t, c, ct string, ht int) error
 	DropIPv4Input(t, c string) error
+	AddIPv4BanSet(c, n string) error
+	BanIPv4(n, ip string, timeout time.Duration) error
+	AddIPv6Rule(ip string) error
+	DeleteIPv6Rule(ip string) error
+	AddIPv6NetworkRule(cidr string) error
+	DeleteIPv6NetworkRule(cidr string) error
+	AddIPv6Set(n string) error
+	AddIPv6SetRule(n string) error
+	AddIPv6ToSetRule(n, ip string) error
+	DeleteIPv6FromAuthorizedList(n, ip string) error
+	AddIPv6TailingReject() error
+	GetIPv6AuthorizedHosts(s string) ([]net.IP, error)
+	CreateIPv6Table(t string) error
+	CreateIPv6Chain(t, c, ct string, ht int) error
+	DropIPv6Input(t, c string) error
+	AddIPv6BanSet(c, n string) error
+	BanIPv6(n, ip string, timeout time.Duration) error
 }
 
 // Firewall for managing firewall rules
@@ -35,6 +53,7 @@ type Firewall struct {
 	ingress chan net.IP
 	backend
 	table, chain string
+	ipv6         bool
 }
 
 func (f *Firewall) backendExecutor(b, h string) (*backend, error) {
@@ -45,7 +64,7 @@ func (f *Firewall) backendExecutor(b, h string) (*backend, error) {
 	}
 
 	if b == "nftables" {
-		nft, err := nftables.NewFirewallBackend(h, f.table, f.chain)
+		nft, err := nftables.NewFirewallBackend(h, f.table, f.chain, f.ipv6)
 		if err != nil {
 			return nil, err
 		}
@@ -66,9 +85,10 @@ func (f *Firewall) backendExecutor(b, h string) (*backend, error) {
 //         hook    = OUTPUT/FORWARD.
 //         table   = table name that will be used/created (nftables).
 //         chain   = chain name that will be created.
+//         ipv6    = also filter IPv6 traffic in an ip6 table with the same name and chain.
 func NewFirewall(
 	backend, hook, table, chain string,
-	dropInput bool,
+	dropInput, ipv6 bool,
 	logger *logrus.Logger,
 ) (*Firewall, error) {
 
@@ -85,6 +105,7 @@ func NewFirewall(
 		ingress: make(chan net.IP),
 		table:   table,
 		chain:   chain,
+		ipv6:    ipv6,
 	}
 
 	log := fw.logger.WithFields(logrus.Fields{
@@ -106,7 +127,60 @@ func NewFirewall(
 		if err != nil {
 			return nil, err
 		}
+
+		if ipv6 {
+			err = fw.DropIPv6Input(table, chain)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return fw, nil
 }
+
+// IPv6 reports if IPv6 traffic is filtered
+func (f *Firewall) IPv6() bool {
+	return f.ipv6
+}
+
+// isIPv6 reports if an address or a network is IPv6
+func isIPv6(addr string) bool {
+	return strings.Contains(addr, ":")
+}
+
+// AddNetworkRule for whitelisting an IPv4 or IPv6 network in the chain
+func (f *Firewall) AddNetworkRule(cidr string) error {
+	if isIPv6(cidr) {
+		return f.AddIPv6NetworkRule(cidr)
+	}
+
+	return f.AddIPv4NetworkRule(cidr)
+}
+
+// AddToSetRule for adding an IPv4 or IPv6 host in the set of its family
+func (f *Firewall) AddToSetRule(n, ip string) error {
+	if isIPv6(ip) {
+		return f.AddIPv6ToSetRule(n, ip)
+	}
+
+	return f.AddIPv4ToSetRule(n, ip)
+}
+
+// DeleteFromAuthorizedList for deleting an IPv4 or IPv6 host from the set of its family
+func (f *Firewall) DeleteFromAuthorizedList(n, ip string) error {
+	if isIPv6(ip) {
+		return f.DeleteIPv6FromAuthorizedList(n, ip)
+	}
+
+	return f.DeleteIPv4FromAuthorizedList(n, ip)
+}
+
+// Ban for adding an IPv4 or IPv6 host in the ban set of its family for the given duration
+func (f *Firewall) Ban(n, ip string, timeout time.Duration) error {
+	if isIPv6(ip) {
+		return f.BanIPv6(n, ip, timeout)
+	}
+
+	return f.BanIPv4(n, ip, timeout)
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/google/nftables/expr"
 )
 
-func (f *FirewallBackend) getChain(c string) (*nftables.Chain, error) {
+func (f *FirewallBackend) getChain(c string, tf nftables.TableFamily) (*nftables.Chain, error) {
 	f.Lock()
 	chains, err := f.nft.ListChains()
 	f.Unlock()
@@ -17,7 +17,7 @@ func (f *FirewallBackend) getChain(c string) (*nftables.Chain, error) {
 		return nil, err
 	}
 	for _, t := range chains {
-		if c == t.Name {
+		if c == t.Name && t.Table.Family == tf {
 			return t, nil
 		}
 	}
@@ -25,7 +25,7 @@ func (f *FirewallBackend) getChain(c string) (*nftables.Chain, error) {
 	return nil, fmt.Errorf(errNoSuchCahin, c)
 }
 
-func (f *FirewallBackend) getTable(c string) (*nftables.Table, error) {
+func (f *FirewallBackend) getTable(c string, tf nftables.TableFamily) (*nftables.Table, error) {
 	f.Lock()
 	tables, err := f.nft.ListTables()
 	f.Unlock()
@@ -35,7 +35,7 @@ func (f *FirewallBackend) getTable(c string) (*nftables.Table, error) {
 	}
 
 	for _, t := range tables {
-		if c == t.Name {
+		if c == t.Name && t.Family == tf {
 			return t, nil
 		}
 	}
@@ -45,7 +45,11 @@ func (f *FirewallBackend) getTable(c string) (*nftables.Table, error) {
 
 // CreateIPv4Table create an nftables table
 func (f *FirewallBackend) CreateIPv4Table(table string) error {
-	_, err := f.getTable(table)
+	return f.createTable(table, nftables.TableFamilyIPv4)
+}
+
+func (f *FirewallBackend) createTable(table string, tf nftables.TableFamily) error {
+	_, err := f.getTable(table, tf)
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "could not find table") {
 			return err
@@ -57,7 +61,7 @@ func (f *FirewallBackend) CreateIPv4Table(table string) error {
 
 	f.nft.AddTable(&nftables.Table{
 		Name:   table,
-		Family: nftables.TableFamilyIPv4,
+		Family: tf,
 	})
 
 	err = f.nft.Flush()
@@ -70,7 +74,11 @@ func (f *FirewallBackend) CreateIPv4Table(table string) error {
 
 // CreateIPv4Chain create an nftables chain in a specific table
 func (f *FirewallBackend) CreateIPv4Chain(table, chain, chainType string, hookType int) error {
-	nt, err := f.getTable(table)
+	return f.createChain(table, chain, chainType, hookType, nftables.TableFamilyIPv4)
+}
+
+func (f *FirewallBackend) createChain(table, chain, chainType string, hookType int, tf nftables.TableFamily) error {
+	nt, err := f.getTable(table, tf)
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "could not find table") {
 			return err
@@ -102,7 +110,7 @@ func (f *FirewallBackend) CreateIPv4Chain(table, chain, chainType string, hookTy
 		hT = nftables.ChainHookForward
 	}
 
-	_, err = f.getChain(chain)
+	_, err = f.getChain(chain, tf)
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "could not find chain") {
 			return err
@@ -218,8 +226,12 @@ func (f *FirewallBackend) createChainInputWithEstablished(table *nftables.Table,
 // is not a tailing verdict it will move it at the end by first creating a new reject verdict at the end of
 // the chain and then deleting the existing one
 func (f *FirewallBackend) AddTailingReject() error {
+	return f.addTailingReject(f.ipv4)
+}
+
+func (f *FirewallBackend) addTailingReject(fam *family) error {
 	f.Lock()
-	rules, err := f.nft.GetRule(f.table, f.chain)
+	rules, err := f.nft.GetRule(fam.table, fam.chain)
 	f.Unlock()
 
 	if err != nil {
@@ -237,8 +249,8 @@ func (f *FirewallBackend) AddTailingReject() error {
 			if i != (totalRules - 1) {
 				f.Lock()
 				f.nft.AddRule(&nftables.Rule{
-					Table: f.table,
-					Chain: f.chain,
+					Table: fam.table,
+					Chain: fam.chain,
 					Exprs: []expr.Any{
 						&expr.Counter{},
 						&expr.Reject{},
@@ -253,8 +265,8 @@ func (f *FirewallBackend) AddTailingReject() error {
 
 				f.Lock()
 				err = f.nft.DelRule(&nftables.Rule{
-					Table:  f.table,
-					Chain:  f.chain,
+					Table:  fam.table,
+					Chain:  fam.chain,
 					Handle: r.Handle,
 				})
 
@@ -278,8 +290,8 @@ func (f *FirewallBackend) AddTailingReject() error {
 	defer f.Unlock()
 
 	f.nft.AddRule(&nftables.Rule{
-		Table: f.table,
-		Chain: f.chain,
+		Table: fam.table,
+		Chain: fam.chain,
 		Exprs: []expr.Any{
 			&expr.Counter{},
 			&expr.Reject{},
@@ -288,50 +300,77 @@ func (f *FirewallBackend) AddTailingReject() error {
 	return f.nft.Flush()
 }
 
+// families returns the address families NetTrust manages
+func (f *FirewallBackend) families() []*family {
+	if f.ipv6 == nil {
+		return []*family{f.ipv4}
+	}
+
+	return []*family{f.ipv4, f.ipv6}
+}
+
 // FlushTable Remove rules from chain. This will leave the chain with the defined policy
 // If the policy is drop, we should run DeleteChain also if we want the host
-// to be able to do network communication
+// to be able to do network communication. The ip and ip6 tables are both flushed
 func (f *FirewallBackend) FlushTable(t string) error {
-	table, err := f.getTable(t)
-	if err != nil {
-		return err
-	}
+	for _, fam := range f.families() {
+		table, err := f.getTable(t, fam.tableFamily)
+		if err != nil {
+			return err
+		}
 
-	f.Lock()
-	defer f.Unlock()
+		f.Lock()
+		f.nft.FlushTable(table)
+		err = f.nft.Flush()
+		f.Unlock()
 
-	f.nft.FlushTable(table)
+		if err != nil {
+			return err
+		}
+	}
 
-	return f.nft.Flush()
+	return nil
 }
 
 // DeleteChain Delete chain from the table. By removing the chain we allow all communication
-// if no other rules are set by external tools
+// if no other rules are set by external tools. The chain is deleted from the ip and ip6 tables
 func (f *FirewallBackend) DeleteChain(c string) error {
-	chain, err := f.getChain(c)
-	if err != nil {
-		return err
-	}
+	for _, fam := range f.families() {
+		chain, err := f.getChain(c, fam.tableFamily)
+		if err != nil {
+			return err
+		}
 
-	f.Lock()
-	defer f.Unlock()
+		f.Lock()
+		f.nft.DelChain(chain)
+		err = f.nft.Flush()
+		f.Unlock()
 
-	f.nft.DelChain(chain)
+		if err != nil {
+			return err
+		}
+	}
 
-	return f.nft.Flush()
+	return nil
 }
 
-// DeleteTable Delete Table from nftables
+// DeleteTable Delete Table from nftables. The ip and ip6 tables are both deleted
 func (f *FirewallBackend) DeleteTable(t string) error {
-	table, err := f.getTable(t)
-	if err != nil {
-		return err
-	}
+	for _, fam := range f.families() {
+		table, err := f.getTable(t, fam.tableFamily)
+		if err != nil {
+			return err
+		}
 
-	f.Lock()
-	defer f.Unlock()
+		f.Lock()
+		f.nft.DelTable(table)
+		err = f.nft.Flush()
+		f.Unlock()
 
-	f.nft.DelTable(table)
+		if err != nil {
+			return err
+		}
+	}
 
-	return f.nft.Flush()
+	return nil
 }
@@ -0,0 +1,206 @@
+package nftables
+
+import (
+	"bytes"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// icmpv6 error messages: destination unreachable, packet too big, time exceeded
+	// and parameter problem
+	icmpv6DestinationUnreachable = 1
+	icmpv6PacketTooBig           = 2
+	icmpv6ParameterProblem       = 4
+
+	// icmpv6 router solicitation, router advertisement, neighbor solicitation and
+	// neighbor advertisement
+	icmpv6RouterSolicitation    = 133
+	icmpv6NeighborAdvertisement = 136
+)
+
+// icmpv6Rule accepts the icmpv6 message types from to to. If related is set, only
+// messages related to a tracked connection are accepted
+type icmpv6Rule struct {
+	from, to byte
+	related  bool
+}
+
+// icmpv6Rules are the icmpv6 messages that are accepted in every ip6 chain. IPv6 hosts
+// resolve link addresses with neighbor discovery, and find the path MTU with packet too
+// big messages, which routers send for traffic in either direction. The other errors
+// are accepted for related traffic
+var icmpv6Rules = []icmpv6Rule{
+	{icmpv6RouterSolicitation, icmpv6NeighborAdvertisement, false},
+	{icmpv6PacketTooBig, icmpv6PacketTooBig, false},
+	{icmpv6DestinationUnreachable, icmpv6ParameterProblem, true},
+}
+
+// CreateIPv6Table create an nftables ip6 table
+func (f *FirewallBackend) CreateIPv6Table(table string) error {
+	return f.createTable(table, nftables.TableFamilyIPv6)
+}
+
+// CreateIPv6Chain create an nftables chain in a specific ip6 table
+func (f *FirewallBackend) CreateIPv6Chain(table, chain, chainType string, hookType int) error {
+	return f.createChain(table, chain, chainType, hookType, nftables.TableFamilyIPv6)
+}
+
+// AddIPv6TailingReject is the IPv6 equivalent of AddTailingReject
+func (f *FirewallBackend) AddIPv6TailingReject() error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.addTailingReject(fam)
+}
+
+// DropIPv6Input for creating an ip6 FILTER/INPUT chain that drops all inbound traffic except
+// loopback traffic, established,related traffic and the icmpv6Rules messages
+func (f *FirewallBackend) DropIPv6Input(table, chain string) error {
+	if _, err := f.family6(); err != nil {
+		return err
+	}
+
+	return f.dropInput(table, nftables.TableFamilyIPv6)
+}
+
+// acceptICMPv6 adds the icmpv6Rules that are not in an ip6 chain yet. These messages
+// are not sent to hosts found in dns answers, so they can not be authorized
+func (f *FirewallBackend) acceptICMPv6(table *nftables.Table, chain *nftables.Chain) error {
+	f.Lock()
+	rules, err := f.nft.GetRule(table, chain)
+	f.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	for _, r := range icmpv6Rules {
+		if r.in(rules) {
+			continue
+		}
+
+		f.nft.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: chain,
+			Exprs: r.exprs(),
+		})
+	}
+
+	return f.nft.Flush()
+}
+
+// exprs returns the expressions of r
+func (r icmpv6Rule) exprs() []expr.Any {
+	exprs := []expr.Any{
+		// [ meta load l4proto => reg 1 ]
+		&expr.Meta{
+			Key:      expr.MetaKeyL4PROTO,
+			Register: 1,
+		},
+		// [ cmp eq reg 1 0x0000003a ]
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{unix.IPPROTO_ICMPV6},
+		},
+	}
+
+	if r.related {
+		exprs = append(exprs,
+			// [ ct load state => reg 1 ]
+			&expr.Ct{
+				Register: 1,
+				Key:      expr.CtKeySTATE,
+			},
+			// [ bitwise reg 1 = (reg=1 & 0x00000004 ) ^ 0x00000000 ]
+			&expr.Bitwise{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Len:            4,
+				Mask:           []byte{0x04, 0x00, 0x00, 0x00},
+				Xor:            []byte{0x00, 0x00, 0x00, 0x00},
+			},
+			// [ cmp neq reg 1 0x00000000 ]
+			&expr.Cmp{
+				Op:       expr.CmpOpNeq,
+				Register: 1,
+				Data:     []byte{0x00, 0x00, 0x00, 0x00},
+			},
+		)
+	}
+
+	exprs = append(exprs,
+		// [ payload load 1b @ transport header + 0 => reg 1 ] (icmpv6 type)
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       0,
+			Len:          1,
+		},
+	)
+
+	if r.from == r.to {
+		// [ cmp eq reg 1 0x02 ]
+		exprs = append(exprs, &expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{r.from},
+		})
+	} else {
+		// [ range eq reg 1 0x85 0x88 ]
+		exprs = append(exprs, &expr.Range{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			FromData: []byte{r.from},
+			ToData:   []byte{r.to},
+		})
+	}
+
+	return append(exprs, &expr.Verdict{Kind: expr.VerdictAccept})
+}
+
+// in reports if one of rules matches the icmpv6 types of r and is limited to related
+// traffic only if r is
+func (r icmpv6Rule) in(rules []*nftables.Rule) bool {
+	for _, rule := range rules {
+		var icmpType, types, related bool
+		for _, e := range rule.Exprs {
+			switch e := e.(type) {
+			case *expr.Ct:
+				related = related || e.Key == expr.CtKeySTATE
+			case *expr.Payload:
+				icmpType = e.Base == expr.PayloadBaseTransportHeader && e.Offset == 0 && e.Len == 1
+			case *expr.Range:
+				types = types || icmpType && e.Op == expr.CmpOpEq &&
+					rangeBound(e.FromData, r.from) && rangeBound(e.ToData, r.to)
+			case *expr.Cmp:
+				types = types || icmpType && r.from == r.to && e.Op == expr.CmpOpEq &&
+					bytes.Equal(e.Data, []byte{r.from})
+			}
+		}
+
+		if types && related == r.related {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rangeBound reports if the bound of a range expression is b. Range bounds read back from
+// the kernel are still wrapped in their netlink attribute (4 bytes header)
+func rangeBound(data []byte, b byte) bool {
+	if len(data) > 4 {
+		data = data[4:5]
+	}
+
+	return bytes.Equal(data, []byte{b})
+}
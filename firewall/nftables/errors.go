@@ -7,4 +7,6 @@ var (
 	errNotSuchIPv4NetRule  string = "could not find network rule with cidr [%s]"
 	errNotSuchIPv4AddrRule string = "could not find rule with ip [%s]"
 	errNoSuchIPv4SetRule   string = "could not find set rule with name [%s]"
+	errNotValidIPv6Addr    string = "[%s] does not appear to be a valid ipv6 ipaddr"
+	errIPv6Disabled        string = "ipv6 is not enabled in the firewall backend"
 )
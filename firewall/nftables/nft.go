@@ -1,22 +1,78 @@
 package nftables
 
 import (
+	"fmt"
+	"net"
 	"sync"
 
 	"github.com/google/nftables"
 )
 
+// family holds the table, chain and header layout NetTrust uses for an address family
+type family struct {
+	table                    *nftables.Table
+	chain                    *nftables.Chain
+	tableFamily              nftables.TableFamily
+	saddrOffset, daddrOffset uint32
+	addrLen                  uint32
+	keyType                  nftables.SetDatatype
+}
+
+var (
+	familyIPv4 = family{
+		tableFamily: nftables.TableFamilyIPv4,
+		saddrOffset: 12,
+		daddrOffset: 16,
+		addrLen:     net.IPv4len,
+		keyType:     nftables.TypeIPAddr,
+	}
+	familyIPv6 = family{
+		tableFamily: nftables.TableFamilyIPv6,
+		saddrOffset: 8,
+		daddrOffset: 24,
+		addrLen:     net.IPv6len,
+		keyType:     nftables.TypeIP6Addr,
+	}
+)
+
+// parseIP parses an address of the family. Returns nil if ip is not a valid address of the family
+func (fam *family) parseIP(ip string) net.IP {
+	netIP := net.ParseIP(ip)
+	if netIP == nil {
+		return nil
+	}
+
+	if fam.tableFamily == nftables.TableFamilyIPv4 {
+		return netIP.To4()
+	}
+
+	if netIP.To4() != nil {
+		return nil
+	}
+
+	return netIP.To16()
+}
+
+// errNotValidAddr returns the invalid address error of the family
+func (fam *family) errNotValidAddr(ip string) error {
+	if fam.tableFamily == nftables.TableFamilyIPv4 {
+		return fmt.Errorf(errNotValidIPv4Addr, ip)
+	}
+
+	return fmt.Errorf(errNotValidIPv6Addr, ip)
+}
+
 // FirewallBackend for nftables
 type FirewallBackend struct {
 	sync.Mutex
 	nft                  *nftables.Conn
 	tableName, chainName string
-	table                *nftables.Table
-	chain                *nftables.Chain
+	ipv4, ipv6           *family
 }
 
-// NewFirewallBackend for creating a new nftables FirewaBackend
-func NewFirewallBackend(hook, table, chain string) (*FirewallBackend, error) {
+// NewFirewallBackend for creating a new nftables FirewaBackend. If ipv6 is set, an ip6 table with the
+// same name and chain is created next to the ip table
+func NewFirewallBackend(hook, table, chain string, ipv6 bool) (*FirewallBackend, error) {
 	firewallBackend := &FirewallBackend{
 		nft:       &nftables.Conn{},
 		tableName: table,
@@ -41,61 +97,100 @@ func NewFirewallBackend(hook, table, chain string) (*FirewallBackend, error) {
 		cT = nftables.ChainTypeFilter
 	}
 
-	err := firewallBackend.CreateIPv4Table(table)
+	ipv4 := familyIPv4
+	err := firewallBackend.createFamily(&ipv4, table, chain, cT, hT)
 	if err != nil {
 		return nil, err
 	}
+	firewallBackend.ipv4 = &ipv4
+
+	if !ipv6 {
+		return firewallBackend, nil
+	}
 
-	nt, err := firewallBackend.getTable(table)
+	ipv6Family := familyIPv6
+	err = firewallBackend.createFamily(&ipv6Family, table, chain, cT, hT)
 	if err != nil {
 		return nil, err
 	}
+	firewallBackend.ipv6 = &ipv6Family
 
-	err = firewallBackend.CreateIPv4Chain(
-		table,
-		chain,
-		string(cT),
-		int(hT),
-	)
+	// Without neighbor discovery and packet too big messages hosts can not
+	// reach each other over IPv6
+	err = firewallBackend.acceptICMPv6(ipv6Family.table, ipv6Family.chain)
 	if err != nil {
 		return nil, err
 	}
 
-	nc, err := firewallBackend.getChain(chain)
+	return firewallBackend, nil
+}
+
+// createFamily creates the table and the chain of an address family
+func (f *FirewallBackend) createFamily(fam *family, table, chain string, cT nftables.ChainType, hT nftables.ChainHook) error {
+	err := f.createTable(table, fam.tableFamily)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	firewallBackend.table = nt
-	firewallBackend.chain = nc
+	nt, err := f.getTable(table, fam.tableFamily)
+	if err != nil {
+		return err
+	}
 
-	return firewallBackend, nil
+	err = f.createChain(table, chain, string(cT), int(hT), fam.tableFamily)
+	if err != nil {
+		return err
+	}
+
+	nc, err := f.getChain(chain, fam.tableFamily)
+	if err != nil {
+		return err
+	}
+
+	fam.table = nt
+	fam.chain = nc
+
+	return nil
+}
+
+// family6 returns the IPv6 family or an error if IPv6 is not enabled
+func (f *FirewallBackend) family6() (*family, error) {
+	if f.ipv6 == nil {
+		return nil, fmt.Errorf(errIPv6Disabled)
+	}
+
+	return f.ipv6, nil
 }
 
 // DropIPv4Input for creating FILTER/INPUT chain that drops all inbound traffic except
 // loopback traffic or established,related traffic
 func (f *FirewallBackend) DropIPv4Input(table, chain string) error {
-	err := f.CreateIPv4Table(table)
+	return f.dropInput(table, nftables.TableFamilyIPv4)
+}
+
+func (f *FirewallBackend) dropInput(table string, tf nftables.TableFamily) error {
+	err := f.createTable(table, tf)
 	if err != nil {
 		return err
 	}
 
-	nti, err := f.getTable(table)
+	nti, err := f.getTable(table, tf)
 	if err != nil {
 		return err
 	}
 
-	err = f.CreateIPv4Chain(
+	err = f.createChain(
 		table,
 		"input",
 		string(nftables.ChainTypeFilter),
 		int(nftables.ChainHookInput),
+		tf,
 	)
 	if err != nil {
 		return err
 	}
 
-	nci, err := f.getChain("input")
+	nci, err := f.getChain("input", tf)
 	if err != nil {
 		return err
 	}
@@ -105,5 +200,9 @@ func (f *FirewallBackend) DropIPv4Input(table, chain string) error {
 		return err
 	}
 
+	if tf == nftables.TableFamilyIPv6 {
+		return f.acceptICMPv6(nti, nci)
+	}
+
 	return nil
 }
@@ -9,9 +9,9 @@ import (
 	"github.com/google/nftables/expr"
 )
 
-func (f *FirewallBackend) getIPv4Rule(ip string) (*nftables.Rule, error) {
+func (f *FirewallBackend) getRule(fam *family, ip string) (*nftables.Rule, error) {
 	f.Lock()
-	rules, err := f.nft.GetRule(f.table, f.chain)
+	rules, err := f.nft.GetRule(fam.table, fam.chain)
 	f.Unlock()
 
 	if err != nil {
@@ -35,12 +35,16 @@ func (f *FirewallBackend) getIPv4Rule(ip string) (*nftables.Rule, error) {
 
 // AddIPv4Rule for adding IPv4 rules in the chain, should never be used after initial chain setup
 func (f *FirewallBackend) AddIPv4Rule(ip string) error {
-	netIP := net.ParseIP(ip).To4()
+	return f.addRule(f.ipv4, ip)
+}
+
+func (f *FirewallBackend) addRule(fam *family, ip string) error {
+	netIP := fam.parseIP(ip)
 	if netIP == nil {
-		return fmt.Errorf(errNotValidIPv4Addr, ip)
+		return fam.errNotValidAddr(ip)
 	}
 
-	_, err := f.getIPv4Rule(ip)
+	_, err := f.getRule(fam, netIP.String())
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "could not find rule with ip") {
 			return err
@@ -53,15 +57,15 @@ func (f *FirewallBackend) AddIPv4Rule(ip string) error {
 	defer f.Unlock()
 
 	f.nft.AddRule(&nftables.Rule{
-		Table: f.table,
-		Chain: f.chain,
+		Table: fam.table,
+		Chain: fam.chain,
 		Exprs: []expr.Any{
 			&expr.Payload{
 				OperationType: expr.PayloadLoad,
 				DestRegister:  1,
 				Base:          expr.PayloadBaseNetworkHeader,
-				Offset:        16,
-				Len:           4,
+				Offset:        fam.daddrOffset,
+				Len:           fam.addrLen,
 			},
 			&expr.Cmp{
 				Op:       expr.CmpOpEq,
@@ -78,12 +82,16 @@ func (f *FirewallBackend) AddIPv4Rule(ip string) error {
 
 // DeleteIPv4Rule for deleting an IPv4 rule from the chain
 func (f *FirewallBackend) DeleteIPv4Rule(ip string) error {
-	netIP := net.ParseIP(ip).To4()
+	return f.deleteRule(f.ipv4, ip)
+}
+
+func (f *FirewallBackend) deleteRule(fam *family, ip string) error {
+	netIP := fam.parseIP(ip)
 	if netIP == nil {
-		return fmt.Errorf(errNotValidIPv4Addr, ip)
+		return fam.errNotValidAddr(ip)
 	}
 
-	r, err := f.getIPv4Rule(ip)
+	r, err := f.getRule(fam, netIP.String())
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "could not find rule with ip") {
 			return err
@@ -91,8 +99,8 @@ func (f *FirewallBackend) DeleteIPv4Rule(ip string) error {
 		return nil
 	}
 
-	r.Chain = f.chain
-	r.Table = f.table
+	r.Chain = fam.chain
+	r.Table = fam.table
 
 	f.Lock()
 	defer f.Unlock()
@@ -0,0 +1,112 @@
+package nftables
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// AddIPv4BanSet for creating a set of banned IPv4 hosts and a FILTER/INPUT chain that drops
+// all inbound traffic from them. Hosts expire from the set after the timeout they were added with.
+// The chain accepts by default and runs before other input chains
+func (f *FirewallBackend) AddIPv4BanSet(c, n string) error {
+	return f.addBanSet(f.ipv4, c, n)
+}
+
+func (f *FirewallBackend) addBanSet(fam *family, c, n string) error {
+	_, err := f.getChain(c, fam.tableFamily)
+	if err == nil {
+		return nil
+	}
+	if !strings.HasPrefix(err.Error(), "could not find chain") {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	set := &nftables.Set{
+		Name:       n,
+		Anonymous:  false,
+		Interval:   false,
+		HasTimeout: true,
+		Table:      fam.table,
+		KeyType:    fam.keyType,
+	}
+	err = f.nft.AddSet(set, []nftables.SetElement{})
+	if err != nil {
+		return err
+	}
+
+	inputPolicy := nftables.ChainPolicyAccept
+	chain := f.nft.AddChain(&nftables.Chain{
+		Name:     c,
+		Table:    fam.table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter - 10,
+		Policy:   &inputPolicy,
+	})
+
+	f.nft.AddRule(&nftables.Rule{
+		Table: fam.table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			// [ payload load saddr => reg 1 ]
+			&expr.Payload{
+				DestRegister: 1,
+				Base:         expr.PayloadBaseNetworkHeader,
+				Offset:       fam.saddrOffset,
+				Len:          fam.addrLen,
+			},
+			&expr.Lookup{
+				SourceRegister: 1,
+				SetName:        set.Name,
+				SetID:          set.ID,
+			},
+			&expr.Counter{},
+			&expr.Verdict{
+				Kind: expr.VerdictDrop,
+			},
+		},
+	})
+
+	return f.nft.Flush()
+}
+
+// BanIPv4 for adding an IPv4 host in a ban set for the given duration
+func (f *FirewallBackend) BanIPv4(n, ip string, timeout time.Duration) error {
+	return f.ban(f.ipv4, n, ip, timeout)
+}
+
+func (f *FirewallBackend) ban(fam *family, n, ip string, timeout time.Duration) error {
+	set, err := f.getSet(fam, n)
+	if err != nil {
+		return err
+	}
+
+	netIP := fam.parseIP(ip)
+	if netIP == nil {
+		return fam.errNotValidAddr(ip)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	err = f.nft.SetAddElements(
+		set,
+		[]nftables.SetElement{
+			{
+				Key:     netIP,
+				Timeout: timeout,
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return f.nft.Flush()
+}
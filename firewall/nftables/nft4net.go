@@ -10,14 +10,14 @@ import (
 	"github.com/google/nftables/expr"
 )
 
-func (f *FirewallBackend) getIPv4NetworkRule(cidr string) (*nftables.Rule, error) {
+func (f *FirewallBackend) getNetworkRule(fam *family, cidr string) (*nftables.Rule, error) {
 	_, n, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return nil, err
 	}
 
 	f.Lock()
-	rules, err := f.nft.GetRule(f.table, f.chain)
+	rules, err := f.nft.GetRule(fam.table, fam.chain)
 	f.Unlock()
 
 	if err != nil {
@@ -56,12 +56,20 @@ func (f *FirewallBackend) getIPv4NetworkRule(cidr string) (*nftables.Rule, error
 // AddIPv4NetworkRule for whitelisting an IPv4 network in the chain. Should be used on initial setup and
 // sometimes for whitelisting new networks in the chain
 func (f *FirewallBackend) AddIPv4NetworkRule(cidr string) error {
+	return f.addNetworkRule(f.ipv4, cidr)
+}
+
+func (f *FirewallBackend) addNetworkRule(fam *family, cidr string) error {
 	_, n, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.getIPv4NetworkRule(cidr)
+	if fam.parseIP(n.IP.String()) == nil {
+		return fam.errNotValidAddr(cidr)
+	}
+
+	_, err = f.getNetworkRule(fam, cidr)
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "could not find network rule with cidr") {
 			return err
@@ -74,22 +82,22 @@ func (f *FirewallBackend) AddIPv4NetworkRule(cidr string) error {
 	defer f.Unlock()
 
 	f.nft.AddRule(&nftables.Rule{
-		Table: f.table,
-		Chain: f.chain,
+		Table: fam.table,
+		Chain: fam.chain,
 		Exprs: []expr.Any{
 			&expr.Payload{
 				OperationType: expr.PayloadLoad,
 				DestRegister:  1,
 				Base:          expr.PayloadBaseNetworkHeader,
-				Offset:        16,
-				Len:           4,
+				Offset:        fam.daddrOffset,
+				Len:           fam.addrLen,
 			},
 			&expr.Bitwise{
 				SourceRegister: 1,
 				DestRegister:   1,
-				Len:            4,
+				Len:            fam.addrLen,
 				Mask:           n.Mask,
-				Xor:            []byte{0x00, 0x00, 0x00, 0x00},
+				Xor:            make([]byte, fam.addrLen),
 			},
 			&expr.Cmp{
 				Op:       expr.CmpOpEq,
@@ -105,12 +113,16 @@ func (f *FirewallBackend) AddIPv4NetworkRule(cidr string) error {
 
 // DeleteIPv4NetworkRule for deleting a whitelisted network from the chain
 func (f *FirewallBackend) DeleteIPv4NetworkRule(cidr string) error {
+	return f.deleteNetworkRule(f.ipv4, cidr)
+}
+
+func (f *FirewallBackend) deleteNetworkRule(fam *family, cidr string) error {
 	_, _, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return err
 	}
 
-	r, err := f.getIPv4NetworkRule(cidr)
+	r, err := f.getNetworkRule(fam, cidr)
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "could not find network rule with cidr") {
 			return err
@@ -118,8 +130,8 @@ func (f *FirewallBackend) DeleteIPv4NetworkRule(cidr string) error {
 		return nil
 	}
 
-	r.Chain = f.chain
-	r.Table = f.table
+	r.Chain = fam.chain
+	r.Table = fam.table
 
 	f.Lock()
 	defer f.Unlock()
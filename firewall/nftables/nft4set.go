@@ -9,9 +9,9 @@ import (
 	"github.com/google/nftables/expr"
 )
 
-func (f *FirewallBackend) getIPv4Set(n string) (*nftables.Set, error) {
+func (f *FirewallBackend) getSet(fam *family, n string) (*nftables.Set, error) {
 	f.Lock()
-	set, err := f.nft.GetSetByName(f.table, n)
+	set, err := f.nft.GetSetByName(fam.table, n)
 	f.Unlock()
 
 	if err != nil {
@@ -21,9 +21,9 @@ func (f *FirewallBackend) getIPv4Set(n string) (*nftables.Set, error) {
 	return set, nil
 }
 
-func (f *FirewallBackend) getIPv4SetRule(n string) (*nftables.Rule, error) {
+func (f *FirewallBackend) getSetRule(fam *family, n string) (*nftables.Rule, error) {
 	f.Lock()
-	rules, err := f.nft.GetRule(f.table, f.chain)
+	rules, err := f.nft.GetRule(fam.table, fam.chain)
 	f.Unlock()
 
 	if err != nil {
@@ -50,7 +50,11 @@ func (f *FirewallBackend) getIPv4SetRule(n string) (*nftables.Rule, error) {
 
 // GetIPv4AuthorizedHosts return a slice of all hosts from a set
 func (f *FirewallBackend) GetIPv4AuthorizedHosts(s string) ([]net.IP, error) {
-	set, err := f.getIPv4Set(s)
+	return f.getAuthorizedHosts(f.ipv4, s)
+}
+
+func (f *FirewallBackend) getAuthorizedHosts(fam *family, s string) ([]net.IP, error) {
+	set, err := f.getSet(fam, s)
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +78,11 @@ func (f *FirewallBackend) GetIPv4AuthorizedHosts(s string) ([]net.IP, error) {
 
 // AddIPv4Set for adding a new IPv4 set in the chain
 func (f *FirewallBackend) AddIPv4Set(n string) error {
-	_, err := f.getIPv4Set(n)
+	return f.addSet(f.ipv4, n)
+}
+
+func (f *FirewallBackend) addSet(fam *family, n string) error {
+	_, err := f.getSet(fam, n)
 	if err == nil {
 		return nil
 	}
@@ -86,8 +94,8 @@ func (f *FirewallBackend) AddIPv4Set(n string) error {
 		Name:      n,
 		Anonymous: false,
 		Interval:  false,
-		Table:     f.table,
-		KeyType:   nftables.TypeIPAddr,
+		Table:     fam.table,
+		KeyType:   fam.keyType,
 	}
 	err = f.nft.AddSet(set, []nftables.SetElement{})
 	if err != nil {
@@ -99,12 +107,16 @@ func (f *FirewallBackend) AddIPv4Set(n string) error {
 
 // AddIPv4SetRule for adding a whitelist rule in the chain for a specific IPv4 set
 func (f *FirewallBackend) AddIPv4SetRule(n string) error {
-	set, err := f.getIPv4Set(n)
+	return f.addSetRule(f.ipv4, n)
+}
+
+func (f *FirewallBackend) addSetRule(fam *family, n string) error {
+	set, err := f.getSet(fam, n)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.getIPv4SetRule(n)
+	_, err = f.getSetRule(fam, n)
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "could not find set rule with name") {
 			return err
@@ -117,14 +129,14 @@ func (f *FirewallBackend) AddIPv4SetRule(n string) error {
 	defer f.Unlock()
 
 	f.nft.AddRule(&nftables.Rule{
-		Table: f.table,
-		Chain: f.chain,
+		Table: fam.table,
+		Chain: fam.chain,
 		Exprs: []expr.Any{
 			&expr.Payload{
 				DestRegister: 1,
 				Base:         expr.PayloadBaseNetworkHeader,
-				Offset:       16,
-				Len:          4,
+				Offset:       fam.daddrOffset,
+				Len:          fam.addrLen,
 			},
 			&expr.Lookup{
 				SourceRegister: 1,
@@ -142,14 +154,18 @@ func (f *FirewallBackend) AddIPv4SetRule(n string) error {
 
 // AddIPv4ToSetRule for adding a new IPv4 host in a set
 func (f *FirewallBackend) AddIPv4ToSetRule(n, ip string) error {
-	set, err := f.getIPv4Set(n)
+	return f.addToSet(f.ipv4, n, ip)
+}
+
+func (f *FirewallBackend) addToSet(fam *family, n, ip string) error {
+	set, err := f.getSet(fam, n)
 	if err != nil {
 		return err
 	}
 
-	netIP := net.ParseIP(ip).To4()
+	netIP := fam.parseIP(ip)
 	if netIP == nil {
-		return fmt.Errorf(errNotValidIPv4Addr, ip)
+		return fam.errNotValidAddr(ip)
 	}
 
 	f.Lock()
@@ -159,7 +175,7 @@ func (f *FirewallBackend) AddIPv4ToSetRule(n, ip string) error {
 		set,
 		[]nftables.SetElement{
 			{
-				Key: netIP.To4(),
+				Key: netIP,
 			},
 		},
 	)
@@ -172,14 +188,18 @@ func (f *FirewallBackend) AddIPv4ToSetRule(n, ip string) error {
 
 // DeleteIPv4FromAuthorizedList for deleting an IPv4 host from a set
 func (f *FirewallBackend) DeleteIPv4FromAuthorizedList(n, ip string) error {
-	set, err := f.getIPv4Set(n)
+	return f.deleteFromSet(f.ipv4, n, ip)
+}
+
+func (f *FirewallBackend) deleteFromSet(fam *family, n, ip string) error {
+	set, err := f.getSet(fam, n)
 	if err != nil {
 		return err
 	}
 
-	netIP := net.ParseIP(ip).To4()
+	netIP := fam.parseIP(ip)
 	if netIP == nil {
-		return fmt.Errorf(errNotValidIPv4Addr, ip)
+		return fam.errNotValidAddr(ip)
 	}
 
 	f.Lock()
@@ -189,7 +209,7 @@ func (f *FirewallBackend) DeleteIPv4FromAuthorizedList(n, ip string) error {
 		set,
 		[]nftables.SetElement{
 			{
-				Key: netIP.To4(),
+				Key: netIP,
 			},
 		},
 	)
@@ -0,0 +1,21 @@
+package nftables
+
+// AddIPv6Rule for adding IPv6 rules in the chain, should never be used after initial chain setup
+func (f *FirewallBackend) AddIPv6Rule(ip string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.addRule(fam, ip)
+}
+
+// DeleteIPv6Rule for deleting an IPv6 rule from the chain
+func (f *FirewallBackend) DeleteIPv6Rule(ip string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.deleteRule(fam, ip)
+}
@@ -0,0 +1,23 @@
+package nftables
+
+import "time"
+
+// AddIPv6BanSet is the IPv6 equivalent of AddIPv4BanSet. The set and the chain are created in the ip6 table
+func (f *FirewallBackend) AddIPv6BanSet(c, n string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.addBanSet(fam, c, n)
+}
+
+// BanIPv6 for adding an IPv6 host in a ban set for the given duration
+func (f *FirewallBackend) BanIPv6(n, ip string, timeout time.Duration) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.ban(fam, n, ip, timeout)
+}
@@ -0,0 +1,22 @@
+package nftables
+
+// AddIPv6NetworkRule for whitelisting an IPv6 network in the chain. Should be used on initial setup and
+// sometimes for whitelisting new networks in the chain
+func (f *FirewallBackend) AddIPv6NetworkRule(cidr string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.addNetworkRule(fam, cidr)
+}
+
+// DeleteIPv6NetworkRule for deleting a whitelisted IPv6 network from the chain
+func (f *FirewallBackend) DeleteIPv6NetworkRule(cidr string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.deleteNetworkRule(fam, cidr)
+}
@@ -0,0 +1,53 @@
+package nftables
+
+import "net"
+
+// GetIPv6AuthorizedHosts return a slice of all hosts from an IPv6 set
+func (f *FirewallBackend) GetIPv6AuthorizedHosts(s string) ([]net.IP, error) {
+	fam, err := f.family6()
+	if err != nil {
+		return nil, err
+	}
+
+	return f.getAuthorizedHosts(fam, s)
+}
+
+// AddIPv6Set for adding a new IPv6 set in the ip6 table
+func (f *FirewallBackend) AddIPv6Set(n string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.addSet(fam, n)
+}
+
+// AddIPv6SetRule for adding a whitelist rule in the chain for a specific IPv6 set
+func (f *FirewallBackend) AddIPv6SetRule(n string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.addSetRule(fam, n)
+}
+
+// AddIPv6ToSetRule for adding a new IPv6 host in a set
+func (f *FirewallBackend) AddIPv6ToSetRule(n, ip string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.addToSet(fam, n, ip)
+}
+
+// DeleteIPv6FromAuthorizedList for deleting an IPv6 host from a set
+func (f *FirewallBackend) DeleteIPv6FromAuthorizedList(n, ip string) error {
+	fam, err := f.family6()
+	if err != nil {
+		return err
+	}
+
+	return f.deleteFromSet(fam, n, ip)
+}